@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// channelPane is the message area of one channel or direct conversation
+// plus its scroll-back state.
+type channelPane struct {
+	area *fyne.Container
+	// title is shown in the sidebar, "#channel" or "@user"
+	title string
+	// unread counts messages that arrived while the pane was not shown
+	unread int
+	// oldest is the lowest message id shown, 0 until one arrives
+	oldest uint64
+	// more reports whether the server has messages older than oldest
+	more bool
+	// loading is set while a scroll-back request is in flight
+	loading bool
+	// messages are the chat messages shown that have an id, sending the
+	// own ones the server hasn't confirmed yet by their ref
+	messages map[uint64]*messageBubble
+	sending  map[string]*messageBubble
+	// replied holds the ids of messages that have replies
+	replied map[uint64]bool
+	// thinking lists the bots working on an answer in the channel
+	thinking []string
+	// typing holds until when each user writing a message is shown as typing
+	typing map[string]time.Time
+	// members is who is in the channel, sorted by name
+	members []protocol.Member
+}
+
+// channelView keeps one pane per joined channel and direct conversation, and
+// the sidebar used to switch between them. Channels are keyed by name, direct
+// conversations by directKey. It must only be touched from the Fyne goroutine.
+type channelView struct {
+	names  []string
+	panes  map[string]*channelPane
+	active string
+
+	// banner is the welcome text shown at the top of the default channel
+	// until the first message is sent
+	banner fyne.CanvasObject
+
+	scroll  *container.Scroll
+	sidebar *widget.List
+	// activity tells which bots are thinking in the channel shown
+	activity *widget.Label
+	// memberList, memberTitle and voiceLabel make up the member panel of the
+	// channel shown
+	memberList  *widget.List
+	memberTitle *widget.Label
+	voiceLabel  *widget.Label
+
+	// onLoadOlder is called when the user scrolls to the top of a channel
+	// that has older messages on the server
+	onLoadOlder func(name string, before uint64)
+	// messageMenu returns what can be done with a message of a channel on
+	// right click, nil for nothing
+	messageMenu func(name string, b *messageBubble) *fyne.Menu
+	// onReact is called when the user picks an emoji for a message of a
+	// channel, or clicks a reaction under it
+	onReact func(name string, b *messageBubble, emoji string)
+	// requestThread asks for the thread of message id in a channel,
+	// showThread shows it once it arrived
+	requestThread func(name string, id uint64)
+	showThread    func(name string, objs []fyne.CanvasObject)
+}
+
+func newChannelView() *channelView {
+	cv := &channelView{
+		panes: map[string]*channelPane{},
+	}
+
+	cv.activity = widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Italic: true})
+	cv.activity.Hide()
+
+	cv.scroll = container.NewVScroll(container.New(layout.NewVBoxLayout()))
+	cv.scroll.OnScrolled = func(pos fyne.Position) {
+		if pos.Y <= 0 {
+			cv.loadOlder()
+		}
+	}
+
+	cv.sidebar = widget.NewList(
+		func() int { return len(cv.names) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			label := obj.(*widget.Label)
+			pane := cv.panes[cv.names[id]]
+			if pane.unread > 0 {
+				label.SetText(fmt.Sprintf("%s (%d)", pane.title, pane.unread))
+			} else {
+				label.SetText(pane.title)
+			}
+			label.TextStyle.Bold = pane.unread > 0
+			label.Refresh()
+		},
+	)
+	cv.sidebar.OnSelected = func(id widget.ListItemID) {
+		if id < len(cv.names) && cv.names[id] != cv.active {
+			cv.show(cv.names[id])
+		}
+	}
+
+	cv.add(protocol.DefaultChannel)
+	cv.show(protocol.DefaultChannel)
+
+	return cv
+}
+
+// add creates the pane for a channel if it does not exist yet and reports
+// whether it did.
+func (cv *channelView) add(name string) bool {
+	return cv.addPane(name, "#"+name)
+}
+
+// addDirect creates the pane for a direct conversation with peer if it does
+// not exist yet and returns its key.
+func (cv *channelView) addDirect(peer string) string {
+	key := directKey(peer)
+	cv.addPane(key, "@"+peer)
+	return key
+}
+
+func (cv *channelView) addPane(key, title string) bool {
+	if _, ok := cv.panes[key]; ok {
+		return false
+	}
+
+	cv.panes[key] = &channelPane{area: container.New(layout.NewVBoxLayout()), title: title}
+	cv.names = append(cv.names, key)
+	cv.sidebar.Refresh()
+	return true
+}
+
+// directKey returns the pane key of the direct conversation with peer. Names
+// are matched case insensitively, like on the server.
+func directKey(peer string) string {
+	return "@" + strings.ToLower(peer)
+}
+
+// directPeer returns the user a pane key belongs to, or false for channels.
+func (cv *channelView) directPeer(key string) (string, bool) {
+	pane, ok := cv.panes[key]
+	if !ok || !strings.HasPrefix(key, "@") {
+		return "", false
+	}
+	return strings.TrimPrefix(pane.title, "@"), true
+}
+
+// remove drops a channel and falls back to the default one if it was shown.
+func (cv *channelView) remove(name string) {
+	i := slices.Index(cv.names, name)
+	if i == -1 || name == protocol.DefaultChannel {
+		return
+	}
+
+	cv.names = slices.Delete(cv.names, i, i+1)
+	delete(cv.panes, name)
+	cv.sidebar.Refresh()
+
+	if cv.active == name {
+		cv.show(protocol.DefaultChannel)
+	}
+}
+
+// show makes name the active channel.
+func (cv *channelView) show(name string) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	cv.active = name
+	pane.unread = 0
+	cv.scroll.Content = pane.area
+	cv.scroll.Refresh()
+	cv.scroll.ScrollToBottom()
+	cv.sidebar.Select(slices.Index(cv.names, name))
+	cv.sidebar.Refresh()
+	cv.showActivity()
+	cv.showMembers()
+}
+
+// setThinking records whether bot is working on an answer in a channel.
+func (cv *channelView) setThinking(name, bot string, active bool) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	pane.thinking = slices.DeleteFunc(pane.thinking, func(b string) bool { return b == bot })
+	if active {
+		pane.thinking = append(pane.thinking, bot)
+	}
+
+	if pane == cv.panes[cv.active] {
+		cv.showActivity()
+	}
+}
+
+// setTyping records whether user is writing a message in a channel or
+// direct conversation. It expires after protocol.TypingTimeout unless it is
+// renewed.
+func (cv *channelView) setTyping(name, user string, active bool) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	if active {
+		if pane.typing == nil {
+			pane.typing = map[string]time.Time{}
+		}
+		pane.typing[user] = time.Now().Add(protocol.TypingTimeout)
+		time.AfterFunc(protocol.TypingTimeout, func() {
+			fyne.Do(func() { cv.expireTyping(name) })
+		})
+	} else {
+		delete(pane.typing, user)
+	}
+
+	if pane == cv.panes[cv.active] {
+		cv.showActivity()
+	}
+}
+
+// expireTyping drops the typing indicators of a channel that weren't renewed.
+func (cv *channelView) expireTyping(name string) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	for user, until := range pane.typing {
+		if !now.Before(until) {
+			delete(pane.typing, user)
+		}
+	}
+
+	if pane == cv.panes[cv.active] {
+		cv.showActivity()
+	}
+}
+
+// showActivity updates the activity line for the channel shown.
+func (cv *channelView) showActivity() {
+	pane := cv.panes[cv.active]
+	if pane == nil {
+		cv.activity.Hide()
+		return
+	}
+
+	var parts []string
+	if len(pane.thinking) > 0 {
+		parts = append(parts, describeActivity(pane.thinking, "thinking"))
+	}
+	if len(pane.typing) > 0 {
+		users := slices.Sorted(maps.Keys(pane.typing))
+		parts = append(parts, describeActivity(users, "typing"))
+	}
+	if len(parts) == 0 {
+		cv.activity.Hide()
+		return
+	}
+
+	cv.activity.SetText(strings.Join(parts, "  "))
+	cv.activity.Show()
+}
+
+// describeActivity says that names are doing something, e.g. "Alice and Bob
+// are typing…".
+func describeActivity(names []string, doing string) string {
+	switch {
+	case len(names) == 1:
+		return fmt.Sprintf("%s is %s…", names[0], doing)
+	case len(names) > 3:
+		return fmt.Sprintf("Several people are %s…", doing)
+	default:
+		return fmt.Sprintf("%s are %s…", strings.Join(names, " and "), doing)
+	}
+}
+
+// pane returns the pane of a channel, or the active one when name is empty
+// or unknown.
+func (cv *channelView) pane(name string) *channelPane {
+	if pane, ok := cv.panes[name]; ok {
+		return pane
+	}
+	return cv.panes[cv.active]
+}
+
+func (cv *channelView) setBanner(obj fyne.CanvasObject) {
+	cv.banner = obj
+	cv.panes[protocol.DefaultChannel].area.Add(obj)
+}
+
+func (cv *channelView) hideBanner() {
+	if cv.banner == nil {
+		return
+	}
+
+	cv.panes[protocol.DefaultChannel].area.Remove(cv.banner)
+	cv.banner = nil
+}
+
+// append adds obj to a channel and keeps the view scrolled if it is shown.
+// id is the message id of obj, 0 for messages without one.
+func (cv *channelView) append(name string, id uint64, obj fyne.CanvasObject) {
+	pane := cv.pane(name)
+	pane.area.Add(obj)
+	pane.track(id)
+
+	if pane == cv.panes[cv.active] {
+		cv.scroll.ScrollToBottom()
+	} else {
+		pane.unread++
+		cv.sidebar.Refresh()
+	}
+}
+
+// addHistory shows a batch of stored messages. Pages requested by scrolling
+// back go above everything shown, replays go below.
+func (cv *channelView) addHistory(name string, page bool, ids []uint64, objs []fyne.CanvasObject, more bool) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	if !page {
+		for i, obj := range objs {
+			pane.area.Add(obj)
+			pane.track(ids[i])
+		}
+		if len(ids) > 0 && ids[0] <= pane.oldest {
+			pane.more = more
+		}
+		if pane == cv.panes[cv.active] {
+			cv.scroll.ScrollToBottom()
+		}
+		return
+	}
+
+	pane.loading = false
+	pane.more = more
+	if len(objs) == 0 {
+		return
+	}
+
+	if name == protocol.DefaultChannel {
+		cv.hideBanner()
+	}
+
+	// Keep the messages currently on screen in place while the page is
+	// inserted above them
+	before := pane.area.MinSize().Height
+	pane.area.Objects = append(slices.Clone(objs), pane.area.Objects...)
+	pane.area.Refresh()
+	for _, id := range ids {
+		pane.track(id)
+	}
+
+	if pane == cv.panes[cv.active] {
+		grown := pane.area.MinSize().Height - before
+		cv.scroll.ScrollToOffset(fyne.NewPos(0, cv.scroll.Offset.Y+grown))
+	}
+}
+
+// loadOlder asks for the page before the oldest message of the active
+// channel, unless one is already on its way.
+func (cv *channelView) loadOlder() {
+	pane := cv.panes[cv.active]
+	if pane == nil || !pane.more || pane.loading || pane.oldest == 0 || cv.onLoadOlder == nil {
+		return
+	}
+
+	pane.loading = true
+	cv.onLoadOlder(cv.active, pane.oldest)
+}
+
+func (p *channelPane) track(id uint64) {
+	if id != 0 && (p.oldest == 0 || id < p.oldest) {
+		p.oldest = id
+	}
+}
@@ -0,0 +1,597 @@
+// Package client is a headless Go Chat client. It speaks the wire protocol
+// and surfaces incoming traffic on channels, leaving rendering to the caller,
+// so the GUI, bots and tests all share the same code path.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// DefaultChatPort and DefaultTokenPort are the ports of the server's chat
+// listener and voice token endpoint unless configured otherwise.
+const (
+	DefaultChatPort  = "8000"
+	DefaultTokenPort = "8080"
+)
+
+// EventType identifies a change in connection state.
+type EventType int
+
+const (
+	// EventConnected is sent once the handshake succeeded
+	EventConnected EventType = iota
+	// EventDisconnected is sent when the connection is lost. Err is a
+	// *GoodbyeError when the server said why it closed the connection and nil
+	// when it hung up without a reason.
+	EventDisconnected
+	// EventReconnecting is sent before each reconnection attempt when
+	// Config.Reconnect is set. Attempt counts from 1, Delay is the wait
+	// before it and Err why the previous attempt failed.
+	EventReconnecting
+	// EventGaveUp is sent when reconnecting stopped because retrying can't
+	// help, such as a refused login. Err says why.
+	EventGaveUp
+)
+
+// Event reports a change in connection state.
+type Event struct {
+	Type EventType
+	Err  error
+
+	// Resumed is set on EventConnected after a reconnection
+	Resumed bool
+	// Attempt and Delay are set on EventReconnecting
+	Attempt int
+	Delay   time.Duration
+}
+
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrClosed       = errors.New("client closed")
+	// ErrRefused wraps the reason the server rejected the handshake
+	ErrRefused = errors.New("server refused connection")
+	// ErrQueueFull is returned by Send while reconnecting once MaxQueued
+	// messages are waiting
+	ErrQueueFull = errors.New("too many messages waiting to be sent")
+)
+
+// MessageTooLargeError is returned by Send and SendDirect for a message over
+// the limit the server announced in its welcome.
+type MessageTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large (%d bytes, max %d)", e.Size, e.Max)
+}
+
+// GoodbyeError is the reason the server gave for closing the connection.
+type GoodbyeError struct {
+	Reason  protocol.Reason
+	Message string
+}
+
+func (e *GoodbyeError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("disconnected by server: %s", e.Reason)
+	}
+	return e.Message
+}
+
+// Config describes who to log in as and where. Either Password or Token
+// must be set, Register creates the account with Password first.
+type Config struct {
+	DisplayName   string
+	ServerAddress string
+	// ChatPort and TokenPort default to DefaultChatPort and DefaultTokenPort
+	ChatPort  string
+	TokenPort string
+
+	Password string
+	Token    string
+	Register bool
+
+	// TLS connects to both the chat socket and the token endpoint over TLS
+	TLS bool
+	// Pins enables trust-on-first-use for certificates that don't verify
+	// against the system roots, such as self-signed ones
+	Pins PinStore
+
+	// Reconnect redials with exponential backoff when an established
+	// connection drops, resuming the session where it left off. Messages
+	// sent in the meantime are queued.
+	Reconnect bool
+}
+
+type Client struct {
+	cfg Config
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+
+	// reconnecting is set while the connection is being restored, queue
+	// holds what was sent in the meantime
+	reconnecting bool
+	queue        []*protocol.Envelope
+
+	// lastID and channels are what a resumed session picks up from, status
+	// and voice the presence it restores
+	lastID   uint64
+	channels map[string]struct{}
+	status   protocol.Status
+	voice    string
+
+	// maxMessageSize is the body limit from the welcome, 0 if the server
+	// didn't send one
+	maxMessageSize int
+	// moderator is set by the welcome of an account that may change anyone's
+	// messages
+	moderator bool
+	// refs numbers the messages sent with Post
+	refs atomic.Uint64
+
+	messages  chan *protocol.Envelope
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+	readers   sync.WaitGroup
+}
+
+// New returns a client for cfg.ServerAddress, a host name or IP without
+// port. Nothing is dialed until Connect is called.
+func New(cfg Config) *Client {
+	cfg.DisplayName = strings.TrimSpace(cfg.DisplayName)
+	cfg.ServerAddress = strings.TrimSpace(cfg.ServerAddress)
+	if cfg.ChatPort = strings.TrimSpace(cfg.ChatPort); cfg.ChatPort == "" {
+		cfg.ChatPort = DefaultChatPort
+	}
+	if cfg.TokenPort = strings.TrimSpace(cfg.TokenPort); cfg.TokenPort == "" {
+		cfg.TokenPort = DefaultTokenPort
+	}
+
+	return &Client{
+		cfg:      cfg,
+		messages: make(chan *protocol.Envelope, 64),
+		events:   make(chan Event, 8),
+		done:     make(chan struct{}),
+		channels: map[string]struct{}{},
+	}
+}
+
+// DisplayName returns the account name, as registered once connected.
+func (c *Client) DisplayName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cfg.DisplayName
+}
+
+func (c *Client) ServerAddress() string {
+	return c.cfg.ServerAddress
+}
+
+// Token returns the login token issued by the server on the last successful
+// Connect. It can be stored and passed in Config.Token to log in later
+// without the password.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cfg.Token
+}
+
+// Messages delivers every envelope received from the server after the
+// handshake. It is closed by Close.
+func (c *Client) Messages() <-chan *protocol.Envelope {
+	return c.messages
+}
+
+// Events delivers connection state changes. It is closed by Close.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Connect dials the server, performs the handshake and starts reading in the
+// background.
+func (c *Client) Connect(ctx context.Context) error {
+	conn, dec, err := c.handshake(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn = conn
+	c.readers.Add(1)
+	c.mu.Unlock()
+
+	go c.run(conn, dec)
+
+	return nil
+}
+
+// handshake dials the server and logs in, resuming a previous session when
+// resume is set.
+func (c *Client) handshake(ctx context.Context, resume *protocol.Resume) (net.Conn, *protocol.Decoder, error) {
+	var d net.Dialer
+
+	c.mu.Lock()
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	var conn net.Conn
+	var err error
+
+	address := net.JoinHostPort(cfg.ServerAddress, cfg.ChatPort)
+	if cfg.TLS {
+		td := tls.Dialer{NetDialer: &d, Config: c.tlsConfig(cfg)}
+		conn, err = td.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		var mismatch *PinMismatchError
+		if errors.As(err, &mismatch) {
+			return nil, nil, mismatch
+		}
+		return nil, nil, fmt.Errorf("error connecting to server: %q", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	auth := &protocol.Auth{Password: cfg.Password, Token: cfg.Token, Register: cfg.Register}
+	if cfg.Password != "" {
+		auth.Token = ""
+	}
+
+	hello := protocol.NewHello(cfg.DisplayName, auth)
+	hello.Resume = resume
+	if err := protocol.WriteFrame(conn, hello); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("error sending display name to server: %q", err)
+	}
+
+	dec := protocol.NewDecoder(conn)
+
+	reply, err := dec.Decode()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("error reading handshake reply: %q", err)
+	}
+
+	if reply.Type != protocol.TypeWelcome {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrRefused, reply.Body)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// Later logins use the issued token, the password is not kept around
+	c.mu.Lock()
+	c.cfg.DisplayName = reply.Sender
+	c.maxMessageSize = reply.MaxMessageSize
+	c.moderator = reply.Moderator
+	if reply.Auth != nil && reply.Auth.Token != "" {
+		c.cfg.Token = reply.Auth.Token
+		c.cfg.Password = ""
+		c.cfg.Register = false
+	}
+	c.mu.Unlock()
+
+	return conn, dec, nil
+}
+
+// run reads from conn until it drops, then reconnects if configured to and
+// carries on with the new connection.
+func (c *Client) run(conn net.Conn, dec *protocol.Decoder) {
+	defer c.readers.Done()
+
+	resumed := false
+	for {
+		c.emit(Event{Type: EventConnected, Resumed: resumed})
+
+		err := c.readLoop(conn, dec)
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		c.emit(Event{Type: EventDisconnected, Err: err})
+
+		if !c.cfg.Reconnect {
+			return
+		}
+
+		conn, dec = c.reconnect()
+		if conn == nil {
+			return
+		}
+		resumed = true
+	}
+}
+
+// Send sends a chat message to room, which must be a channel the client has
+// joined. The server relays it to everyone but the sender, so callers render
+// their own messages locally.
+func (c *Client) Send(room, text string) error {
+	return c.send(protocol.NewChat(room, c.DisplayName(), text))
+}
+
+// Post is Send returning a reference to the message. The server confirms it
+// with a TypeSent envelope carrying the reference and the message id, which
+// Edit and Delete need.
+func (c *Client) Post(room, text string) (string, error) {
+	return c.post(protocol.NewChat(room, c.DisplayName(), text))
+}
+
+// Reply is Post for a message answering the one with id parent in room.
+func (c *Client) Reply(room string, parent uint64, text string) (string, error) {
+	msg := protocol.NewChat(room, c.DisplayName(), text)
+	msg.ReplyTo = parent
+
+	return c.post(msg)
+}
+
+func (c *Client) post(msg *protocol.Envelope) (string, error) {
+	msg.Ref = strconv.FormatUint(c.refs.Add(1), 10)
+
+	return msg.Ref, c.send(msg)
+}
+
+// Edit replaces the text of the message with id in room. Only the author
+// and moderators may, others get a TypeError back.
+func (c *Client) Edit(room string, id uint64, text string) error {
+	return c.send(protocol.NewEdit(room, id, text))
+}
+
+// Delete removes the message with id in room, with the same rules as Edit.
+func (c *Client) Delete(room string, id uint64) error {
+	return c.send(protocol.NewDelete(room, id))
+}
+
+// React adds the emoji reaction of the user to the message with id in room,
+// or takes it back when active is not set.
+func (c *Client) React(room string, id uint64, emoji string, active bool) error {
+	return c.send(protocol.NewReact(room, id, emoji, active))
+}
+
+// IsModerator reports whether the account may edit and delete anyone's
+// messages, not just its own.
+func (c *Client) IsModerator() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.moderator
+}
+
+// SendDirect sends a private message to the user named to. Like Send, the
+// sending client renders it locally, other connections of the same account
+// receive a copy as a TypeDirect envelope.
+func (c *Client) SendDirect(to, text string) error {
+	return c.send(protocol.NewDirect(c.DisplayName(), to, text))
+}
+
+// SetPresence tells the server whether the user is around and which
+// channel's voice room it is in, empty for none. It is restored after a
+// reconnect.
+func (c *Client) SetPresence(status protocol.Status, voice string) error {
+	c.mu.Lock()
+	c.status = status
+	c.voice = voice
+	c.mu.Unlock()
+
+	env := &protocol.Envelope{Type: protocol.TypePresence, Status: status, Room: voice, Voice: voice != "", Time: time.Now()}
+	return c.send(env)
+}
+
+// Typing tells room whether the user is writing a message. Call it with
+// active set at most every protocol.TypingTimeout/2 while the user types, and
+// with it cleared once they stop. Nothing is queued while reconnecting.
+func (c *Client) Typing(room string, active bool) error {
+	return c.sendLive(protocol.NewTyping(room, c.DisplayName(), active))
+}
+
+// TypingDirect is Typing for the direct conversation with the user to.
+func (c *Client) TypingDirect(to string, active bool) error {
+	env := protocol.NewTyping("", c.DisplayName(), active)
+	env.To = to
+	return c.sendLive(env)
+}
+
+// sendLive writes env only if connected, for frames that are pointless once
+// they are stale.
+func (c *Client) sendLive(env *protocol.Envelope) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	if err := protocol.WriteFrame(conn, env); err != nil {
+		return fmt.Errorf("error sending to server: %q", err)
+	}
+
+	return nil
+}
+
+// MaxMessageSize returns the largest message body in bytes the server
+// accepts, 0 when unknown.
+func (c *Client) MaxMessageSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.maxMessageSize
+}
+
+// send writes a message frame, or queues it while reconnecting.
+func (c *Client) send(env *protocol.Envelope) error {
+	c.mu.Lock()
+	if c.maxMessageSize > 0 && len(env.Body) > c.maxMessageSize {
+		defer c.mu.Unlock()
+		return &MessageTooLargeError{Size: len(env.Body), Max: c.maxMessageSize}
+	}
+
+	conn := c.conn
+	if conn == nil && c.reconnecting && !c.closed {
+		defer c.mu.Unlock()
+
+		if len(c.queue) >= MaxQueued {
+			return ErrQueueFull
+		}
+		c.queue = append(c.queue, env)
+		return nil
+	}
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	if err := protocol.WriteFrame(conn, env); err != nil {
+		return fmt.Errorf("error sending message to server: %q", err)
+	}
+
+	return nil
+}
+
+// Pending returns how many messages are queued until the connection is
+// restored.
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.queue)
+}
+
+// RequestHistory asks for the page of room's history before the message id
+// before. The reply arrives on Messages as a TypeHistory envelope with ID set
+// to before.
+func (c *Client) RequestHistory(room string, before uint64) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	req := &protocol.Envelope{Type: protocol.TypeHistory, Room: room, ID: before, Time: time.Now()}
+	if err := protocol.WriteFrame(conn, req); err != nil {
+		return fmt.Errorf("error requesting history: %q", err)
+	}
+
+	return nil
+}
+
+// RequestThread asks for the thread the message with id in room is part of.
+// The reply arrives on Messages as a TypeThread envelope.
+func (c *Client) RequestThread(room string, id uint64) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	req := &protocol.Envelope{Type: protocol.TypeThread, Room: room, ID: id, Time: time.Now()}
+	if err := protocol.WriteFrame(conn, req); err != nil {
+		return fmt.Errorf("error requesting thread: %q", err)
+	}
+
+	return nil
+}
+
+// Close disconnects and closes the Messages and Events channels.
+func (c *Client) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		c.closed = true
+		if c.conn != nil {
+			err = c.conn.Close()
+			c.conn = nil
+		}
+		c.mu.Unlock()
+
+		// Only close the channels once no reader can send on them anymore
+		c.readers.Wait()
+		close(c.messages)
+		close(c.events)
+	})
+
+	return err
+}
+
+// readLoop delivers frames from conn until it fails, returning why. It
+// returns ErrClosed when the client was closed.
+func (c *Client) readLoop(conn net.Conn, dec *protocol.Decoder) error {
+	defer func() {
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+			// Queue messages from now on, before anyone hears about the drop
+			c.reconnecting = c.cfg.Reconnect
+		}
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		env, err := dec.Decode()
+		if err != nil {
+			select {
+			case <-c.done:
+				return ErrClosed
+			default:
+			}
+
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+
+		if env.Type == protocol.TypeGoodbye {
+			return &GoodbyeError{Reason: env.Reason, Message: env.Body}
+		}
+
+		c.track(env)
+
+		select {
+		case c.messages <- env:
+		case <-c.done:
+			return ErrClosed
+		}
+	}
+}
+
+func (c *Client) emit(ev Event) {
+	select {
+	case c.events <- ev:
+	case <-c.done:
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// MaxQueued is how many messages Send queues while reconnecting.
+const MaxQueued = 100
+
+// Reconnection waits start at minBackoff and double up to maxBackoff.
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	dialTimeout = 10 * time.Second
+)
+
+// backoff returns the wait before the given attempt, with some jitter so
+// clients dropped together don't all come back at once.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 6 {
+		d = min(minBackoff<<(attempt-1), maxBackoff)
+	}
+
+	return d - time.Duration(rand.Int64N(int64(d/5)))
+}
+
+// reconnect redials until a connection is restored, the client is closed or
+// the server refuses the login. It returns a nil conn when giving up.
+func (c *Client) reconnect() (net.Conn, *protocol.Decoder) {
+	defer func() {
+		c.mu.Lock()
+		c.reconnecting = false
+		c.mu.Unlock()
+	}()
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		delay := backoff(attempt)
+		c.emit(Event{Type: EventReconnecting, Attempt: attempt, Delay: delay, Err: lastErr})
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return nil, nil
+		}
+
+		// Closing the client abandons a dial in progress
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		conn, dec, err := c.handshake(ctx, c.resumeState())
+		cancel()
+
+		if err == nil {
+			if err = c.attach(conn); err == nil {
+				return conn, dec
+			}
+			if errors.Is(err, ErrClosed) {
+				return nil, nil
+			}
+		}
+
+		var mismatch *PinMismatchError
+		if errors.Is(err, ErrRefused) || errors.As(err, &mismatch) {
+			c.emit(Event{Type: EventGaveUp, Err: err})
+			return nil, nil
+		}
+		lastErr = err
+	}
+}
+
+// attach makes conn the live connection after sending everything queued
+// while offline, so nothing sent later overtakes it.
+func (c *Client) attach(conn net.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return ErrClosed
+	}
+
+	for len(c.queue) > 0 {
+		if err := protocol.WriteFrame(conn, c.queue[0]); err != nil {
+			conn.Close()
+			return err
+		}
+		c.queue = c.queue[1:]
+	}
+
+	c.conn = conn
+	return nil
+}
+
+// track remembers the newest stored message id seen and the channels joined,
+// for resuming the session later. Only chat messages, the confirmations of
+// our own and replays carry ids of stored messages, other frames either have
+// none or refer to an older one.
+func (c *Client) track(env *protocol.Envelope) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch env.Type {
+	case protocol.TypeChat, protocol.TypeSent:
+		c.lastID = max(c.lastID, env.ID)
+	case protocol.TypeHistory:
+		for _, m := range env.History {
+			c.lastID = max(c.lastID, m.ID)
+		}
+	}
+
+	switch env.Type {
+	case protocol.TypeJoined:
+		c.channels[env.Room] = struct{}{}
+	case protocol.TypeLeft:
+		delete(c.channels, env.Room)
+	}
+}
+
+func (c *Client) resumeState() *protocol.Resume {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &protocol.Resume{LastID: c.lastID, Status: c.status, Voice: c.voice}
+	for name := range c.channels {
+		r.Channels = append(r.Channels, name)
+	}
+	slices.Sort(r.Channels)
+
+	return r
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// PinStore remembers the certificate fingerprint of each server for
+// trust-on-first-use. Implementations decide where pins live.
+type PinStore interface {
+	// Pin returns the fingerprint pinned for address, if any
+	Pin(address string) (string, bool)
+	SetPin(address, fingerprint string) error
+}
+
+// PinMismatchError is returned when a server presents a certificate other
+// than the one pinned for it.
+type PinMismatchError struct {
+	Address     string
+	Pinned      string
+	Fingerprint string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate of %s changed: pinned %s, got %s", e.Address, e.Pinned, e.Fingerprint)
+}
+
+// tlsConfig verifies servers against the system roots. If that fails and a
+// PinStore is configured, the certificate is trusted on first use and must
+// match its pin afterwards.
+func (c *Client) tlsConfig(cfg Config) *tls.Config {
+	return &tls.Config{
+		ServerName: cfg.ServerAddress,
+		MinVersion: tls.VersionTLS12,
+		// Verification happens in VerifyConnection so pinned self-signed
+		// certificates can be accepted
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+
+			leaf := cs.PeerCertificates[0]
+			opts := x509.VerifyOptions{
+				DNSName:       cfg.ServerAddress,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+
+			_, verifyErr := leaf.Verify(opts)
+			if cfg.Pins == nil {
+				return verifyErr
+			}
+
+			fingerprint := protocol.CertFingerprint(leaf.Raw)
+			pinned, ok := cfg.Pins.Pin(cfg.ServerAddress)
+			if ok {
+				if pinned != fingerprint {
+					return &PinMismatchError{Address: cfg.ServerAddress, Pinned: pinned, Fingerprint: fingerprint}
+				}
+				return nil
+			}
+
+			if verifyErr == nil {
+				return nil
+			}
+
+			return cfg.Pins.SetPin(cfg.ServerAddress, fingerprint)
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// memoryPins is a PinStore that keeps the pins in a map.
+type memoryPins struct {
+	mu   sync.Mutex
+	pins map[string]string
+}
+
+func (p *memoryPins) Pin(address string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.pins[address]
+	return pin, ok
+}
+
+func (p *memoryPins) SetPin(address, fingerprint string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pins[address] = fingerprint
+	return nil
+}
+
+// A self-signed certificate is pinned on first use and any other
+// certificate for the same server is refused.
+func TestPinning(t *testing.T) {
+	dir := t.TempDir()
+	cfg := server.Config{
+		TCPAddr:       "127.0.0.1:0",
+		TLSSelfSigned: true,
+		TLSCertFile:   filepath.Join(dir, "cert.pem"),
+		TLSKeyFile:    filepath.Join(dir, "key.pem"),
+	}
+	pins := &memoryPins{pins: map[string]string{}}
+
+	start := func() *server.Server {
+		t.Helper()
+
+		s := server.New(cfg)
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// Restarts come back on the same port
+		cfg.TCPAddr = s.Addr().String()
+		return s
+	}
+	connect := func(name string) error {
+		_, port, _ := net.SplitHostPort(cfg.TCPAddr)
+		c := client.New(client.Config{
+			DisplayName:   name,
+			ServerAddress: "127.0.0.1",
+			ChatPort:      port,
+			Password:      "password123",
+			Register:      true,
+			TLS:           true,
+			Pins:          pins,
+		})
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return c.Connect(ctx)
+	}
+
+	s := start()
+	if err := connect("alice"); err != nil {
+		t.Fatalf("first connect: %v", err)
+	}
+	pinned, ok := pins.Pin("127.0.0.1")
+	if !ok || pinned == "" {
+		t.Fatal("first connect didn't pin the certificate")
+	}
+	if err := connect("bob"); err != nil {
+		t.Errorf("connect with the pinned certificate: %v", err)
+	}
+
+	// The certificate written to disk survives a restart
+	s.Shutdown(context.Background())
+	s = start()
+	if err := connect("carol"); err != nil {
+		t.Errorf("connect after restart: %v", err)
+	}
+
+	s.Shutdown(context.Background())
+	if err := os.Remove(cfg.TLSCertFile); err != nil {
+		t.Fatal(err)
+	}
+	s = start()
+	defer s.Shutdown(context.Background())
+
+	err := connect("dave")
+	var mismatch *client.PinMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Pinned != pinned || mismatch.Fingerprint == pinned {
+		t.Fatalf("connect with a new certificate: %v, want a pin mismatch", err)
+	}
+	if pin, _ := pins.Pin("127.0.0.1"); pin != pinned {
+		t.Errorf("pin changed to %s", pin)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// VoiceToken is a LiveKit join token for a channel's voice room.
+type VoiceToken struct {
+	JoinToken string `json:"jwtToken"`
+	HostURL   string `json:"hostUrl"`
+}
+
+// VoiceToken asks the server's token endpoint for credentials to the voice
+// room of a channel, authenticated with the login token.
+func (c *Client) VoiceToken(ctx context.Context, room string) (*VoiceToken, error) {
+	c.mu.Lock()
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	scheme := "http"
+	httpClient := http.DefaultClient
+	if cfg.TLS {
+		scheme = "https"
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig(cfg)}}
+	}
+
+	query := url.Values{"name": {cfg.DisplayName}, "room": {room}}
+	tokenURL := fmt.Sprintf("%s://%s/token?%s", scheme, net.JoinHostPort(cfg.ServerAddress, cfg.TokenPort), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %q", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %q", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token request failed: %q", body)
+	}
+
+	var vt VoiceToken
+	if err := json.NewDecoder(resp.Body).Decode(&vt); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	return &vt, nil
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"embed"
 	_ "embed"
+	"errors"
 	"fmt"
 	"image/color"
-	"io"
 	"log"
-	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -20,8 +21,10 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
-	utils "github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
 	ui "github.com/anthonybliss1/fyne-go-chat/chat/theme"
+	"github.com/anthonybliss1/fyne-go-chat/chat/voice"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
 )
 
 //go:embed icon.png assets/*
@@ -63,6 +66,7 @@ func init() {
 
 func generateConnectionWindow(a fyne.App) fyne.Window {
 	w := a.NewWindow("Connect to Server")
+	prefs := a.Preferences()
 
 	title := canvas.NewText("Connect to Server", color.White)
 	title.TextSize = 30
@@ -71,51 +75,137 @@ func generateConnectionWindow(a fyne.App) fyne.Window {
 
 	displayName := widget.NewEntry()
 	displayName.SetPlaceHolder("Display Name")
+	displayName.SetText(prefs.String("lastDisplayName"))
+
+	password := widget.NewPasswordEntry()
+	password.SetPlaceHolder("Password (empty to use saved login)")
 
 	serverAddress := widget.NewEntry()
 	serverAddress.SetPlaceHolder("Server Address")
+	serverAddress.SetText(prefs.String("lastServerAddress"))
+
+	useTLS := widget.NewCheck("Use TLS", nil)
+	useTLS.SetChecked(prefs.Bool("lastUseTLS"))
+
+	chatPort := widget.NewEntry()
+	chatPort.SetPlaceHolder(client.DefaultChatPort)
+	chatPort.SetText(prefs.StringWithFallback("lastChatPort", client.DefaultChatPort))
+
+	tokenPort := widget.NewEntry()
+	tokenPort.SetPlaceHolder(client.DefaultTokenPort)
+	tokenPort.SetText(prefs.StringWithFallback("lastTokenPort", client.DefaultTokenPort))
+
+	for _, port := range []*widget.Entry{chatPort, tokenPort} {
+		port.Validator = func(s string) error {
+			if n, err := strconv.Atoi(s); err != nil || n < 1 || n > 65535 {
+				return errors.New("not a port number")
+			}
+			return nil
+		}
+	}
 
-	connectBtn := widget.NewButtonWithIcon("Connect", connectIcon, func() {
+	settings := widget.NewAccordion(widget.NewAccordionItem("Ports", widget.NewForm(
+		widget.NewFormItem("Chat", chatPort),
+		widget.NewFormItem("Voice Token", tokenPort),
+	)))
+
+	var connect func(register bool)
+	connect = func(register bool) {
 		if displayName.Text == "" || serverAddress.Text == "" {
 			dialog.ShowInformation("Missing Credentials", "Please enter a display name and server address", w)
-		} else {
-			conn, t := dialServer(w, displayName, serverAddress)
+			return
+		}
 
-			if t {
-				w.Hide()
-				msgr := generateMessengerWindow(a, displayName.Text, serverAddress.Text, conn)
-				msgr.CenterOnScreen()
-				msgr.Show()
-			}
+		if password.Text == "" && (register || prefs.String(tokenKey(serverAddress.Text, displayName.Text)) == "") {
+			dialog.ShowInformation("Missing Credentials", "Please enter a password", w)
+			return
 		}
-	})
+
+		if chatPort.Validate() != nil || tokenPort.Validate() != nil {
+			dialog.ShowInformation("Invalid Port", "Ports must be numbers between 1 and 65535", w)
+			return
+		}
+
+		prefs.SetString("lastChatPort", chatPort.Text)
+		prefs.SetString("lastTokenPort", tokenPort.Text)
+
+		c, err := dialServer(displayName, password, serverAddress, useTLS.Checked, register)
+
+		var mismatch *client.PinMismatchError
+		if errors.As(err, &mismatch) {
+			msg := fmt.Sprintf("The certificate of %s is not the one seen before.\n\nPinned:\n%s\n\nPresented:\n%s\n\nOnly trust it if the server owner changed it.", mismatch.Address, mismatch.Pinned, mismatch.Fingerprint)
+			dialog.ShowConfirm("Certificate Changed", msg, func(trust bool) {
+				if trust {
+					prefPins{prefs}.forget(mismatch.Address)
+					connect(register)
+				}
+			}, w)
+			return
+		}
+
+		if err != nil {
+			dialog.ShowInformation("Error Connecting to Server", fmt.Sprintf("%s", err), w)
+			return
+		}
+
+		if c != nil {
+			password.SetText("")
+			w.Hide()
+			msgr := generateMessengerWindow(a, c)
+			msgr.CenterOnScreen()
+			msgr.Show()
+		}
+	}
+
+	connectBtn := widget.NewButtonWithIcon("Connect", connectIcon, func() { connect(false) })
+	registerBtn := widget.NewButton("Register", func() { connect(true) })
 
 	w.SetContent(container.NewVBox(
 		title,
 		layout.NewSpacer(),
 		displayName,
+		password,
 		layout.NewSpacer(),
 		serverAddress,
+		useTLS,
+		settings,
 		layout.NewSpacer(),
-		connectBtn,
+		container.NewGridWithColumns(2, registerBtn, connectBtn),
 		layout.NewSpacer(),
 	))
 
 	w.SetOnClosed(func() { a.Quit() })
 
-	w.Resize(fyne.NewSize(400, 200))
-	w.SetFixedSize(true)
+	w.Resize(fyne.NewSize(400, 300))
 
 	return w
 }
 
-func generateMessengerWindow(a fyne.App, displayName, serverAddress string, conn net.Conn) fyne.Window {
-	var isBanner, isVoice = false, false
+// tokenKey is the preference key of the saved login token for an account on
+// a server
+func tokenKey(serverAddress, displayName string) string {
+	return "token:" + strings.TrimSpace(serverAddress) + ":" + strings.ToLower(strings.TrimSpace(displayName))
+}
+
+func generateMessengerWindow(a fyne.App, c *client.Client) fyne.Window {
+	var isVoice = false
+	var voiceChannel string
+	var away = false
+	displayName := c.DisplayName()
 	var voiceBtn *widget.Button
 
 	w := a.NewWindow("Go Chat Messenger")
 
-	msgArea := container.New(layout.NewVBoxLayout())
+	channels := newChannelView()
+
+	// status shows the connection state at the bottom of the sidebar
+	status := widget.NewLabel("● Connected")
+	status.Importance = widget.SuccessImportance
+	channels.onLoadOlder = func(name string, before uint64) {
+		if err := c.RequestHistory(name, before); err != nil {
+			dialog.ShowInformation("Error Loading History", fmt.Sprintf("%s", err), w)
+		}
+	}
 
 	banner := `
  ______     ______        ______     __  __     ______     ______
@@ -129,46 +219,218 @@ Welcome to Go Chat!
 
 `
 	goChatLabel := widget.NewLabelWithStyle(fmt.Sprintf(banner, displayName), fyne.TextAlignCenter, fyne.TextStyle{})
-	msgArea.Add(goChatLabel)
-	isBanner = true
-
-	scrollArea := container.NewVScroll(msgArea)
+	channels.setBanner(goChatLabel)
 
 	msg := widget.NewEntry()
 	msg.SetPlaceHolder("Send a message...")
 
+	// replying is the message the next one in replyRoom answers, shown in
+	// replyBar above the message box
+	var replying *messageBubble
+	var replyRoom string
+	replyLabel := widget.NewLabel("")
+	replyLabel.Truncation = fyne.TextTruncateEllipsis
+	var replyBar *fyne.Container
+	setReply := func(room string, b *messageBubble) {
+		replying, replyRoom = b, room
+		if b == nil {
+			replyBar.Hide()
+			return
+		}
+		replyLabel.SetText(fmt.Sprintf("Replying to <%s> in #%s: %s", b.sender, room, protocol.NewQuote(b.sender, b.body).Body))
+		replyBar.Show()
+		w.Canvas().Focus(msg)
+	}
+	replyBar = container.NewBorder(nil, nil, nil, widget.NewButtonWithIcon("", theme.CancelIcon(), func() { setReply("", nil) }), replyLabel)
+	replyBar.Hide()
+
+	channels.onReact = func(name string, b *messageBubble, emoji string) {
+		if err := c.React(name, b.id, emoji, !b.reacted(emoji, displayName)); err != nil {
+			dialog.ShowInformation("Error Reacting", fmt.Sprintf("%s", err), w)
+		}
+	}
+
+	channels.requestThread = func(name string, id uint64) {
+		if err := c.RequestThread(name, id); err != nil {
+			dialog.ShowInformation("Error Loading Thread", fmt.Sprintf("%s", err), w)
+		}
+	}
+	channels.showThread = func(name string, objs []fyne.CanvasObject) {
+		thread := dialog.NewCustom("Thread in #"+name, "Close", container.NewVScroll(container.NewVBox(objs...)), w)
+		thread.Resize(fyne.NewSize(700, 500))
+		thread.Show()
+	}
+
+	channels.messageMenu = func(name string, b *messageBubble) *fyne.Menu {
+		if b.deleted || b.streaming {
+			return nil
+		}
+
+		react := fyne.NewMenuItem("React", nil)
+		var picker []*fyne.MenuItem
+		for _, emoji := range reactionPicker {
+			item := fyne.NewMenuItem(emoji, func() { channels.onReact(name, b, emoji) })
+			item.Checked = b.reacted(emoji, displayName)
+			picker = append(picker, item)
+		}
+		react.ChildMenu = fyne.NewMenu("", picker...)
+
+		items := []*fyne.MenuItem{react, fyne.NewMenuItem("Reply", func() { setReply(name, b) })}
+		if channels.inThread(name, b) {
+			items = append(items, fyne.NewMenuItem("View Thread", func() { channels.requestThread(name, b.id) }))
+		}
+
+		if !strings.EqualFold(b.sender, displayName) && !c.IsModerator() {
+			return fyne.NewMenu("", items...)
+		}
+
+		edit := fyne.NewMenuItem("Edit", func() {
+			text := widget.NewMultiLineEntry()
+			text.Wrapping = fyne.TextWrapWord
+			text.SetText(b.body)
+			dialog.ShowForm("Edit Message", "Save", "Cancel", []*widget.FormItem{widget.NewFormItem("Message", text)}, func(ok bool) {
+				if !ok || text.Text == b.body || strings.TrimSpace(text.Text) == "" {
+					return
+				}
+				err := c.Edit(name, b.id, text.Text)
+				var tooLarge *client.MessageTooLargeError
+				if errors.As(err, &tooLarge) {
+					dialog.ShowInformation("Message Too Long", fmt.Sprintf("Messages can be at most %d bytes, this one is %d.", tooLarge.Max, tooLarge.Size), w)
+				} else if err != nil {
+					dialog.ShowInformation("Error Editing Message", fmt.Sprintf("%s", err), w)
+				}
+			}, w)
+		})
+		del := fyne.NewMenuItem("Delete", func() {
+			dialog.ShowConfirm("Delete Message", "Delete this message for everyone?", func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := c.Delete(name, b.id); err != nil {
+					dialog.ShowInformation("Error Deleting Message", fmt.Sprintf("%s", err), w)
+				}
+			}, w)
+		})
+
+		items = append(items, fyne.NewMenuItemSeparator(), edit, del)
+		return fyne.NewMenu("", items...)
+	}
+
+	// typingRoom is where the user was last said to be typing and typingSent
+	// when, so the indicator is renewed only every TypingTimeout/2. A pause
+	// as long stops it.
+	var typingRoom string
+	var typingSent time.Time
+	var typingIdle *time.Timer
+
+	sendTyping := func(room string, active bool) {
+		if peer, ok := channels.directPeer(room); ok {
+			c.TypingDirect(peer, active)
+		} else {
+			c.Typing(room, active)
+		}
+	}
+	stopTyping := func() {
+		if typingIdle != nil {
+			typingIdle.Stop()
+		}
+		if typingRoom != "" {
+			sendTyping(typingRoom, false)
+			typingRoom = ""
+		}
+	}
+	msg.OnChanged = func(text string) {
+		room := channels.active
+		if text == "" || room != typingRoom {
+			stopTyping()
+		}
+		if text == "" {
+			return
+		}
+
+		if typingRoom == "" || time.Since(typingSent) >= protocol.TypingTimeout/2 {
+			sendTyping(room, true)
+			typingRoom, typingSent = room, time.Now()
+		}
+		if typingIdle != nil {
+			typingIdle.Stop()
+		}
+		typingIdle = time.AfterFunc(protocol.TypingTimeout/2, func() { fyne.Do(stopTyping) })
+	}
+
 	send := func() {
 		if msg.Text != "" {
-			if isBanner {
-				msgArea.Remove(goChatLabel)
-				isBanner = false
+			channels.hideBanner()
+
+			room := channels.active
+			text := msg.Text
+			var ref string
+			var err error
+
+			if to, body, ok := parseDirect(text); ok {
+				// Private messages get their own conversation instead of
+				// showing up in the channel they were typed in
+				room = channels.addDirect(to)
+				channels.show(room)
+				text = body
+				err = c.SendDirect(to, body)
+			} else if peer, ok := channels.directPeer(room); ok {
+				err = c.SendDirect(peer, text)
+			} else if replying != nil && replyRoom == room {
+				ref, err = c.Reply(room, replying.id, text)
+			} else {
+				ref, err = c.Post(room, text)
 			}
 
-			msgBubble := generateMessageBubble(msg.Text, displayName, true)
-			fyne.Do(func() {
-				msgArea.Add(msgBubble)
-				scrollArea.ScrollToBottom()
-			})
-			if err := utils.SendMessage(conn, displayName, msg.Text); err != nil {
+			// Nothing was sent, keep the text so it can be shortened
+			var tooLarge *client.MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				dialog.ShowInformation("Message Too Long", fmt.Sprintf("Messages can be at most %d bytes, this one is %d.", tooLarge.Max, tooLarge.Size), w)
+				return
+			}
+
+			// send runs on the Fyne goroutine, the bubble is waiting for its
+			// confirmation before that can be handled
+			msgBubble, b := generateMessageBubble(text, displayName, true, false)
+			if replying != nil && replyRoom == room {
+				b.setQuote(replying.id, protocol.NewQuote(replying.sender, replying.body))
+				setReply("", nil)
+			}
+			channels.append(room, 0, msgBubble)
+			if err == nil && ref != "" {
+				channels.await(room, ref, b)
+			}
+			if err != nil {
 				dialog.ShowInformation("Error Sending Message", fmt.Sprintf("%s", err), w)
+			} else if n := c.Pending(); n > 0 {
+				status.SetText(fmt.Sprintf("● Offline, %d queued", n))
 			}
 			msg.SetText("")
 		}
 	}
 
-	startVoiceChat := func() {
-		if isBanner {
-			msgArea.Remove(goChatLabel)
-			isBanner = false
+	// updatePresence tells the server whether the user is around and in
+	// voice, errors are ignored since the presence is restored on reconnect
+	updatePresence := func() {
+		status, room := protocol.StatusOnline, ""
+		if away {
+			status = protocol.StatusAway
 		}
+		if isVoice {
+			room = voiceChannel
+		}
+		c.SetPresence(status, room)
+	}
+
+	startVoiceChat := func() {
+		channels.hideBanner()
 		msg := fmt.Sprintf("%s Entered the Voice Chat", displayName)
 		msgBubble := generateVoiceChatBubble(msg, true)
-		utils.PlaySound("sounds/joinVC.mp3")
+		voice.PlaySound("sounds/joinVC.mp3")
 		fyne.Do(func() {
-			msgArea.Add(msgBubble)
-			scrollArea.ScrollToBottom()
+			channels.append(voiceChannel, 0, msgBubble)
 		})
-		if err := utils.SendMessage(conn, displayName, msg); err != nil {
+		if err := c.Send(voiceChannel, msg); err != nil {
 			dialog.ShowInformation("Error Sending Message", fmt.Sprintf("%s", err), w)
 		}
 	}
@@ -176,13 +438,12 @@ Welcome to Go Chat!
 	stopVoiceChat := func() {
 		msg := fmt.Sprintf("%s Left the Voice Chat", displayName)
 		msgBubble := generateVoiceChatBubble(msg, true)
-		utils.PlaySound("sounds/leaveVC.mp3")
+		voice.PlaySound("sounds/leaveVC.mp3")
 		fyne.Do(func() {
-			msgArea.Add(msgBubble)
-			scrollArea.ScrollToBottom()
+			channels.append(voiceChannel, 0, msgBubble)
 			voiceBtn.SetIcon(voiceIcon)
 		})
-		if err := utils.SendMessage(conn, displayName, msg); err != nil {
+		if err := c.Send(voiceChannel, msg); err != nil {
 			dialog.ShowInformation("Error Sending Message", fmt.Sprintf("%s", err), w)
 		}
 	}
@@ -191,18 +452,45 @@ Welcome to Go Chat!
 
 	voiceBtn = widget.NewButtonWithIcon("", voiceIcon, func() {
 		if isVoice == false {
-			fyne.Do(func() { voiceBtn.SetIcon(cancelIcon); startVoiceChat() })
+			if _, ok := channels.directPeer(channels.active); ok {
+				dialog.ShowInformation("Voice Chat", "Voice chat is only available in channels", w)
+				return
+			}
+			room := channels.active
+			voiceChannel = room
+			// Set right away so a second click can't start another join, the
+			// button stays disabled until voice chat started or failed
+			isVoice = true
+			voiceBtn.SetIcon(cancelIcon)
+			voiceBtn.Disable()
 			go func() {
-				if err := utils.StartVoice("GO_CHAT", displayName, serverAddress); err != nil {
-					dialog.ShowInformation("Error Starting Voice Chat", fmt.Sprint(err), w)
-					return
-				}
+				err := func() error {
+					vt, err := c.VoiceToken(context.Background(), room)
+					if err != nil {
+						return err
+					}
+					return voice.StartVoice(vt.HostURL, vt.JoinToken, displayName)
+				}()
+
+				fyne.Do(func() {
+					voiceBtn.Enable()
+					if err != nil {
+						isVoice = false
+						voiceBtn.SetIcon(voiceIcon)
+						updatePresence()
+						dialog.ShowInformation("Error Starting Voice Chat", fmt.Sprint(err), w)
+						return
+					}
+
+					startVoiceChat()
+					updatePresence()
+				})
 			}()
-			isVoice = true
 		} else {
-			utils.RoomDisconnect()
+			voice.RoomDisconnect()
 			fyne.Do(func() { stopVoiceChat() })
 			isVoice = false
+			updatePresence()
 		}
 	})
 
@@ -210,23 +498,72 @@ Welcome to Go Chat!
 
 	msgInput := container.NewBorder(nil, nil, nil, btnBox, msg)
 
-	w.SetContent(container.NewBorder(nil, msgInput, nil, nil, scrollArea))
+	joinBtn := widget.NewButtonWithIcon("", theme.ContentAddIcon(), func() {
+		name := widget.NewEntry()
+		name.SetPlaceHolder("channel-name or @user")
+		dialog.ShowForm("Join Channel or Message User", "Open", "Cancel", []*widget.FormItem{widget.NewFormItem("Name", name)}, func(ok bool) {
+			name.Text = strings.TrimSpace(name.Text)
+			if !ok || name.Text == "" {
+				return
+			}
+			if peer, ok := strings.CutPrefix(name.Text, "@"); ok {
+				channels.show(channels.addDirect(peer))
+				return
+			}
+			// Direct conversations can't carry channel commands
+			room := channels.active
+			if _, ok := channels.directPeer(room); ok {
+				room = protocol.DefaultChannel
+			}
+			if err := c.Send(room, "#join "+name.Text); err != nil {
+				dialog.ShowInformation("Error Joining Channel", fmt.Sprintf("%s", err), w)
+			}
+		}, w)
+	})
+
+	channelHeader := container.NewBorder(nil, nil, nil, joinBtn, widget.NewLabelWithStyle("Channels", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	sidebar := container.NewBorder(channelHeader, status, nil, nil, channels.sidebar)
+
+	chatPane := container.NewBorder(nil, container.NewVBox(channels.activity, replyBar, msgInput), nil, nil, channels.scroll)
+
+	members := container.NewHSplit(chatPane, channels.newMemberList())
+	members.Offset = 0.8
+
+	split := container.NewHSplit(sidebar, members)
+	split.Offset = 0.18
+
+	w.SetContent(split)
 
 	msg.OnSubmitted = func(_ string) {
 		send()
 	}
 
-	w.Resize(fyne.NewSize(900, 600))
+	a.Lifecycle().SetOnExitedForeground(func() {
+		away = true
+		updatePresence()
+	})
+	a.Lifecycle().SetOnEnteredForeground(func() {
+		away = false
+		updatePresence()
+	})
+
+	w.Resize(fyne.NewSize(1100, 600))
 	w.SetFixedSize(true)
 
-	w.SetOnClosed(func() { a.Quit() })
+	w.SetOnClosed(func() { c.Close(); a.Quit() })
 
-	go incomingMessage(conn, msgArea, scrollArea)
+	go incomingMessage(c, channels, status)
 
 	return w
 }
 
-func generateMessageBubble(msg string, displayName string, isUser bool) *fyne.Container {
+// generateMessageBubble renders a chat message. The returned messageBubble
+// applies later edits and deletes to it.
+func generateMessageBubble(msg string, displayName string, isUser, isBot bool) (*fyne.Container, *messageBubble) {
+	if isBot {
+		return generateBotBubble(msg, displayName)
+	}
+
 	var bubble *canvas.Rectangle
 
 	msgLabel := widget.NewLabel(msg)
@@ -235,12 +572,24 @@ func generateMessageBubble(msg string, displayName string, isUser bool) *fyne.Co
 	nameLabel := canvas.NewText(" "+"<"+displayName+">", color.NRGBA{R: 128, G: 128, B: 128, A: 255})
 	nameLabel.TextSize = 12
 
-	switch displayName {
-	case "Server":
+	b := &messageBubble{
+		sender:  displayName,
+		body:    msg,
+		quote:   newQuoteArea(),
+		name:    nameLabel,
+		setText: msgLabel.SetText,
+		tombstone: func() {
+			msgLabel.TextStyle.Italic = true
+			msgLabel.SetText(deletedText)
+		},
+	}
+
+	switch {
+	case displayName == protocol.SenderServer:
 		orange := color.NRGBA{R: 224, G: 51, B: 11, A: 100}
 		bubble = canvas.NewRectangle(orange)
 
-	case "AI":
+	case displayName == protocol.SenderAI:
 		blue := color.NRGBA{R: 11, G: 109, B: 224, A: 100}
 		bubble = canvas.NewRectangle(blue)
 
@@ -252,26 +601,111 @@ func generateMessageBubble(msg string, displayName string, isUser bool) *fyne.Co
 	bubble.CornerRadius = 12
 	bubble.SetMinSize(fyne.NewSize(400, 20))
 
-	content := container.NewBorder(nil, nameLabel, nil, nil, msgLabel)
+	content := container.NewBorder(b.quote, nameLabel, nil, nil, msgLabel)
+
+	b.area = newMessageArea(container.NewStack(
+		bubble,
+		container.NewPadded(content),
+	))
 
 	if isUser {
 		return container.New(layout.NewHBoxLayout(),
 			layout.NewSpacer(),
-			container.NewStack(
-				bubble,
-				container.NewPadded(content),
-			),
-		)
+			b.withReactions(true),
+		), b
 	} else {
 		return container.New(layout.NewHBoxLayout(),
-			container.NewStack(
-				bubble,
-				container.NewPadded(content),
-			),
-		)
+			b.withReactions(false),
+		), b
 	}
 }
 
+// generateBotBubble renders a message of an AI persona as markdown, which
+// keeps being replaced while the answer is streamed in.
+func generateBotBubble(msg string, displayName string) (*fyne.Container, *messageBubble) {
+	text := widget.NewRichTextFromMarkdown(msg)
+	text.Wrapping = fyne.TextWrapWord
+
+	nameLabel := canvas.NewText(" "+"<"+displayName+">", color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	nameLabel.TextSize = 12
+
+	blue := color.NRGBA{R: 11, G: 109, B: 224, A: 100}
+	bubble := canvas.NewRectangle(blue)
+	bubble.CornerRadius = 12
+	bubble.SetMinSize(fyne.NewSize(400, 20))
+
+	b := &messageBubble{
+		sender:    displayName,
+		body:      msg,
+		quote:     newQuoteArea(),
+		name:      nameLabel,
+		setText:   text.ParseMarkdown,
+		tombstone: func() { text.ParseMarkdown("*" + deletedText + "*") },
+	}
+
+	content := container.NewBorder(b.quote, nameLabel, nil, nil, text)
+	b.area = newMessageArea(container.NewStack(
+		bubble,
+		container.NewPadded(content),
+	))
+
+	return container.New(layout.NewHBoxLayout(),
+		b.withReactions(false),
+		layout.NewSpacer(),
+	), b
+}
+
+// generateStoredBubble renders a message replayed from the history, with
+// its current state.
+func generateStoredBubble(m *protocol.Envelope, displayName string) (*fyne.Container, *messageBubble) {
+	bubble, b := generateMessageBubble(m.Body, m.Sender, m.Sender == displayName, m.Bot)
+	if m.Type != protocol.TypeChat {
+		return bubble, b
+	}
+
+	b.setQuote(m.ReplyTo, m.Quote)
+	switch {
+	case m.Deleted:
+		b.remove()
+	case m.Edited:
+		b.edit(m.Body, true)
+	}
+	b.setReactions(m.Reactions, displayName)
+	b.streaming = m.Streaming
+
+	return bubble, b
+}
+
+// generateEphemeralBubble renders a server reply only this user got, outlined
+// instead of filled so it stands apart from the conversation. Errors get a
+// red outline.
+func generateEphemeralBubble(msg string, isError bool) *fyne.Container {
+	msgLabel := widget.NewLabelWithStyle(msg, fyne.TextAlignLeading, fyne.TextStyle{Italic: true})
+	msgLabel.Wrapping = fyne.TextWrapWord
+
+	nameLabel := canvas.NewText(" <Server> only visible to you", color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	nameLabel.TextSize = 12
+
+	bubble := canvas.NewRectangle(color.Transparent)
+	bubble.StrokeColor = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	if isError {
+		bubble.StrokeColor = color.NRGBA{R: 224, G: 51, B: 11, A: 255}
+	}
+	bubble.StrokeWidth = 1.5
+	bubble.CornerRadius = 12
+	bubble.SetMinSize(fyne.NewSize(400, 20))
+
+	content := container.NewBorder(nil, nameLabel, nil, nil, msgLabel)
+
+	return container.New(layout.NewHBoxLayout(),
+		container.NewStack(
+			bubble,
+			container.NewPadded(content),
+		),
+		layout.NewSpacer(),
+	)
+}
+
 func generateVoiceChatBubble(msg string, isUser bool) *fyne.Container {
 	var bubble *canvas.Rectangle
 
@@ -307,63 +741,303 @@ func generateVoiceChatBubble(msg string, isUser bool) *fyne.Container {
 	}
 }
 
-func dialServer(window fyne.Window, displayName, serverAddress *widget.Entry) (net.Conn, bool) {
-	conn, err := utils.EstablishConnection(displayName.Text, serverAddress.Text)
-	if err != nil {
-		dialog.ShowInformation("Error Connecting to Server", fmt.Sprintf("%s", err), window)
-		return nil, false
+func dialServer(displayName, password, serverAddress *widget.Entry, useTLS, register bool) (*client.Client, error) {
+	prefs := fyne.CurrentApp().Preferences()
+	key := tokenKey(serverAddress.Text, displayName.Text)
+
+	c := client.New(client.Config{
+		DisplayName:   displayName.Text,
+		ServerAddress: serverAddress.Text,
+		Password:      password.Text,
+		Token:         prefs.String(key),
+		ChatPort:      prefs.String("lastChatPort"),
+		TokenPort:     prefs.String("lastTokenPort"),
+		Register:      register,
+		TLS:           useTLS,
+		Pins:          prefPins{prefs},
+		Reconnect:     true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Connect(ctx); err != nil {
+		if password.Text == "" && errors.Is(err, client.ErrRefused) {
+			// The saved token was rejected, don't try it again
+			prefs.RemoveValue(key)
+		}
+		return nil, err
 	}
 
-	utils.PlaySound("sounds/zelda_secret.mp3")
-	return conn, true
+	prefs.SetString(key, c.Token())
+	prefs.SetString("lastDisplayName", displayName.Text)
+	prefs.SetString("lastServerAddress", serverAddress.Text)
+	prefs.SetBool("lastUseTLS", useTLS)
+
+	voice.PlaySound("sounds/zelda_secret.mp3")
+	return c, nil
 }
 
-func incomingMessage(conn net.Conn, msgArea *fyne.Container, scrollArea *container.Scroll) {
-	var msgBubble *fyne.Container
-	rd := bufio.NewReader(conn)
+// parseDirect splits `#dm <user> "message"` into the recipient and the
+// message. The quotes are optional.
+func parseDirect(text string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(text), "#dm ")
+	if !ok {
+		return "", "", false
+	}
+
+	to, body, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	to = strings.TrimPrefix(to, "@")
+	body = strings.TrimSpace(body)
+	if len(body) >= 2 && strings.HasPrefix(body, `"`) && strings.HasSuffix(body, `"`) {
+		body = body[1 : len(body)-1]
+	}
+	if to == "" || body == "" {
+		return "", "", false
+	}
+
+	return to, body, true
+}
+
+func incomingMessage(c *client.Client, channels *channelView, status *widget.Label) {
+	setStatus := func(text string, importance widget.Importance) {
+		fyne.Do(func() {
+			status.Importance = importance
+			status.SetText(text)
+		})
+	}
+
+	serverBubble := func(text string) {
+		msgBubble, _ := generateMessageBubble(text, "Server", false, false)
+		fyne.Do(func() {
+			channels.append(channels.active, 0, msgBubble)
+		})
+	}
+
+	messages, events := c.Messages(), c.Events()
 	for {
-		line, err := rd.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				msgBubble = generateMessageBubble(fmt.Sprintf("%q", err), "Server", false)
-				utils.PlaySound("sounds/noti.mp3")
-				fyne.Do(func() {
-					msgArea.Add(msgBubble)
-					scrollArea.ScrollToBottom()
-				})
-				break
-			} else {
-				msgBubble = generateMessageBubble("<Server Disconnected>", "Server", false)
-				utils.PlaySound("sounds/noti.mp3")
-				fyne.Do(func() {
-					msgArea.Add(msgBubble)
-					scrollArea.ScrollToBottom()
-				})
-				break
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
 			}
+
+			switch ev.Type {
+			case client.EventConnected:
+				setStatus("● Connected", widget.SuccessImportance)
+				if ev.Resumed {
+					serverBubble("<Reconnected>")
+				}
+
+			case client.EventDisconnected:
+				// Messages received before the connection dropped may still be buffered
+				for len(messages) > 0 {
+					showMessage(<-messages, c.DisplayName(), channels)
+				}
+
+				var goodbye *client.GoodbyeError
+				if errors.As(ev.Err, &goodbye) {
+					serverBubble(fmt.Sprintf("<%s>", goodbye.Message))
+				} else if ev.Err != nil {
+					serverBubble(fmt.Sprintf("%q", ev.Err))
+				} else {
+					serverBubble("<Server Disconnected>")
+				}
+				voice.PlaySound("sounds/noti.mp3")
+				setStatus("● Disconnected", widget.DangerImportance)
+
+			case client.EventReconnecting:
+				text := fmt.Sprintf("● Reconnecting in %ds (attempt %d)", int(ev.Delay.Round(time.Second)/time.Second), ev.Attempt)
+				if n := c.Pending(); n > 0 {
+					text += fmt.Sprintf(", %d queued", n)
+				}
+				setStatus(text, widget.WarningImportance)
+
+			case client.EventGaveUp:
+				serverBubble(fmt.Sprintf("%q", ev.Err))
+				setStatus("● Offline", widget.DangerImportance)
+			}
+
+		case env, ok := <-messages:
+			if !ok {
+				return
+			}
+			showMessage(env, c.DisplayName(), channels)
 		}
+	}
+}
+
+func showMessage(env *protocol.Envelope, displayName string, channels *channelView) {
+	var msgBubble *fyne.Container
 
-		if t, senderName, text := utils.ExtractName(strings.TrimRight(line, "\r\n")); t {
-			msgBubble = generateMessageBubble(text, senderName, false)
-			utils.PlaySound("sounds/noti.mp3")
+	switch env.Type {
+	case protocol.TypeHistory:
+		ids := make([]uint64, 0, len(env.History))
+		bubbles := make([]fyne.CanvasObject, 0, len(env.History))
+		// Chat messages can still be edited and deleted after the replay,
+		// answers still being written keep filling in
+		messages := map[uint64]*messageBubble{}
+		for _, m := range env.History {
+			ids = append(ids, m.ID)
+			bubble, b := generateStoredBubble(m, displayName)
+			bubbles = append(bubbles, bubble)
+			if m.Type == protocol.TypeChat {
+				messages[m.ID] = b
+			}
+		}
+		fyne.Do(func() {
+			channels.addHistory(env.Room, env.ID != 0, ids, bubbles, env.More)
+			for id, b := range messages {
+				channels.register(env.Room, id, b)
+			}
+		})
+		return
+	case protocol.TypeEdit:
+		fyne.Do(func() {
+			channels.edit(env.Room, env.ID, env.Body, env.Streaming, env.Edited)
+		})
+		return
+	case protocol.TypeDelete:
+		fyne.Do(func() {
+			channels.removeMessage(env.Room, env.ID)
+		})
+		return
+	case protocol.TypeThread:
+		bubbles := make([]fyne.CanvasObject, 0, len(env.History))
+		for _, m := range env.History {
+			bubble, _ := generateStoredBubble(m, displayName)
+			bubbles = append(bubbles, bubble)
+		}
+		fyne.Do(func() {
+			if channels.showThread != nil {
+				channels.showThread(env.Room, bubbles)
+			}
+		})
+		return
+	case protocol.TypeReact:
+		fyne.Do(func() {
+			channels.setReactions(env.Room, env.ID, env.Reactions, displayName)
+		})
+		return
+	case protocol.TypeSent:
+		fyne.Do(func() {
+			channels.confirm(env.Room, env.Ref, env.ID)
+		})
+		return
+	case protocol.TypeMembers:
+		fyne.Do(func() {
+			channels.setMembers(env.Room, env.Members)
+		})
+		return
+	case protocol.TypePresence:
+		m := protocol.Member{Name: env.Sender, Status: env.Status, Voice: env.Voice}
+		fyne.Do(func() {
+			channels.updateMember(env.Room, m)
+		})
+		return
+	case protocol.TypeThinking:
+		fyne.Do(func() {
+			channels.setThinking(env.Room, env.Sender, env.Active)
+		})
+		return
+	case protocol.TypeTyping:
+		if strings.EqualFold(env.Sender, displayName) {
+			return
+		}
+		room := env.Room
+		if env.To != "" {
+			room = directKey(env.Sender)
+		}
+		fyne.Do(func() {
+			channels.setTyping(room, env.Sender, env.Active)
+		})
+		return
+	case protocol.TypeJoined:
+		fyne.Do(func() {
+			// Rejoins after a reconnection keep the current channel shown
+			if channels.add(env.Room) {
+				channels.show(env.Room)
+			}
+		})
+		return
+	case protocol.TypeLeft:
+		fyne.Do(func() {
+			channels.remove(env.Room)
+		})
+		return
+	case protocol.TypeDirect:
+		// Copies of messages sent from another client of the same account
+		// come back with the peer in To
+		isUser := strings.EqualFold(env.Sender, displayName)
+		peer := env.Sender
+		if isUser {
+			peer = env.To
+		}
+		msgBubble, _ = generateMessageBubble(env.Body, env.Sender, isUser, false)
+		if !isUser {
+			voice.PlaySound("sounds/noti.mp3")
+			fyne.CurrentApp().SendNotification(&fyne.Notification{
+				Title: "@" + peer + ": " + env.Body,
+			})
+		}
+		fyne.Do(func() {
+			key := channels.addDirect(peer)
+			channels.setTyping(key, env.Sender, false)
+			channels.append(key, env.ID, msgBubble)
+		})
+		return
+	case protocol.TypeChat:
+		bubble, b := generateMessageBubble(env.Body, env.Sender, false, env.Bot)
+		b.streaming = env.Streaming
+		b.setQuote(env.ReplyTo, env.Quote)
+		msgBubble = bubble
+		fyne.Do(func() {
+			channels.register(env.Room, env.ID, b)
+			channels.setTyping(env.Room, env.Sender, false)
+		})
+	case protocol.TypeNotice, protocol.TypeError:
+		if env.Ephemeral || env.Type == protocol.TypeError {
+			msgBubble = generateEphemeralBubble(env.Body, env.Type == protocol.TypeError)
 		} else {
-			msgBubble = generateMessageBubble(strings.TrimRight(line, "\r\n"), "Server", false)
-			utils.PlaySound("sounds/noti.mp3")
+			msgBubble, _ = generateMessageBubble(env.Body, protocol.SenderServer, false, false)
 		}
+	default:
+		return
+	}
 
-		fyne.CurrentApp().SendNotification(&fyne.Notification{
-			Title: strings.TrimRight(line, "\r\n"),
-		})
+	room := env.Room
+	if env.Type == protocol.TypeError {
+		room = ""
+	}
 
+	// Replies to the user's own commands need no sound or notification
+	if env.Ephemeral {
 		fyne.Do(func() {
-			msgArea.Add(msgBubble)
-			scrollArea.ScrollToBottom()
+			channels.append(room, env.ID, msgBubble)
 		})
+		return
 	}
+
+	voice.PlaySound("sounds/noti.mp3")
+
+	title := env.Body
+	if env.Type == protocol.TypeChat {
+		title = env.Sender + ": " + env.Body
+	}
+	if env.Room != "" {
+		title = "#" + env.Room + " " + title
+	}
+	fyne.CurrentApp().SendNotification(&fyne.Notification{
+		Title: title,
+	})
+
+	fyne.Do(func() {
+		channels.append(room, env.ID, msgBubble)
+	})
 }
 
 func main() {
-	a := app.New()
+	a := app.NewWithID("com.anthonybliss1.gochat")
 
 	base := theme.DefaultTheme()
 	a.Settings().SetTheme(&ui.ForcedVariant{
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+var (
+	onlineColor = color.NRGBA{R: 67, G: 181, B: 129, A: 255}
+	awayColor   = color.NRGBA{R: 250, G: 166, B: 26, A: 255}
+)
+
+// newMemberList returns the panel listing the members of the channel shown,
+// with a status dot each and a speaker for those in its voice room.
+func (cv *channelView) newMemberList() fyne.CanvasObject {
+	cv.memberList = widget.NewList(
+		func() int { return len(cv.shownMembers()) },
+		func() fyne.CanvasObject {
+			dot := canvas.NewCircle(onlineColor)
+			return container.NewHBox(
+				container.NewCenter(container.NewGridWrap(fyne.NewSize(10, 10), dot)),
+				widget.NewLabel(""),
+				widget.NewIcon(theme.VolumeUpIcon()),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			members := cv.shownMembers()
+			if id >= len(members) {
+				return
+			}
+			m := members[id]
+
+			row := obj.(*fyne.Container)
+			dot := row.Objects[0].(*fyne.Container).Objects[0].(*fyne.Container).Objects[0].(*canvas.Circle)
+			if m.Status == protocol.StatusAway {
+				dot.FillColor = awayColor
+			} else {
+				dot.FillColor = onlineColor
+			}
+			dot.Refresh()
+
+			row.Objects[1].(*widget.Label).SetText(m.Name)
+			if m.Voice {
+				row.Objects[2].Show()
+			} else {
+				row.Objects[2].Hide()
+			}
+		},
+	)
+
+	cv.memberTitle = widget.NewLabelWithStyle("Members", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	cv.voiceLabel = widget.NewLabel("")
+	cv.voiceLabel.Wrapping = fyne.TextWrapWord
+
+	return container.NewBorder(cv.memberTitle, cv.voiceLabel, nil, nil, cv.memberList)
+}
+
+// shownMembers returns the member list of the channel shown, nil for direct
+// conversations.
+func (cv *channelView) shownMembers() []protocol.Member {
+	if pane, ok := cv.panes[cv.active]; ok {
+		return pane.members
+	}
+	return nil
+}
+
+// setMembers replaces the member list of a channel.
+func (cv *channelView) setMembers(name string, members []protocol.Member) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	pane.members = members
+	if pane == cv.panes[cv.active] {
+		cv.showMembers()
+	}
+}
+
+// updateMember applies a presence change to the member list of a channel.
+// Offline members are removed.
+func (cv *channelView) updateMember(name string, m protocol.Member) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	pane.members = slices.DeleteFunc(pane.members, func(o protocol.Member) bool {
+		return strings.EqualFold(o.Name, m.Name)
+	})
+	if m.Status != protocol.StatusOffline {
+		pane.members = append(pane.members, m)
+		slices.SortFunc(pane.members, func(a, b protocol.Member) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+	}
+
+	if pane == cv.panes[cv.active] {
+		cv.showMembers()
+	}
+}
+
+// showMembers refreshes the member panel for the channel shown.
+func (cv *channelView) showMembers() {
+	if cv.memberList == nil {
+		return
+	}
+
+	members := cv.shownMembers()
+	var voice []string
+	for _, m := range members {
+		if m.Voice {
+			voice = append(voice, m.Name)
+		}
+	}
+
+	cv.memberTitle.SetText(fmt.Sprintf("Members (%d)", len(members)))
+	if len(voice) == 0 {
+		cv.voiceLabel.SetText("Nobody in voice")
+	} else {
+		cv.voiceLabel.SetText("In voice: " + strings.Join(voice, ", "))
+	}
+	cv.memberList.Refresh()
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// deletedText replaces the text of a deleted message.
+const deletedText = "message deleted"
+
+// reactionPicker are the emoji offered to react with.
+var reactionPicker = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// messageBubble is a chat message on screen, kept so edits and deletes of it
+// can be applied later.
+type messageBubble struct {
+	sender string
+	body   string
+	// id is the message id, 0 until the server confirmed an own message
+	id uint64
+	// replyTo is the id of the message this one answers
+	replyTo uint64
+	// streaming is set while a bot is still writing the message
+	streaming bool
+	deleted   bool
+
+	reactions []protocol.Reaction
+	// react toggles the reaction of the user with an emoji, nil until the
+	// message has an id
+	react func(emoji string)
+
+	area  *messageArea
+	quote *quoteArea
+	name  *canvas.Text
+	// bar holds a button per reaction, barRow is it aligned with the bubble
+	bar    *fyne.Container
+	barRow fyne.CanvasObject
+	// setText shows a new text, tombstone the note that it was deleted
+	setText   func(body string)
+	tombstone func()
+}
+
+// edit replaces the text of the message, and notes that it was changed when
+// edited is set.
+func (b *messageBubble) edit(body string, edited bool) {
+	if b.deleted {
+		return
+	}
+
+	b.body = body
+	b.setText(body)
+	if edited {
+		b.name.Text = " <" + b.sender + "> (edited)"
+		b.name.Refresh()
+	}
+}
+
+// remove tombstones the message.
+func (b *messageBubble) remove() {
+	b.deleted = true
+	b.streaming = false
+	b.body = ""
+	b.tombstone()
+	b.name.Text = " <" + b.sender + ">"
+	b.name.Refresh()
+	b.quote.Hide()
+	b.setReactions(nil, "")
+}
+
+// setQuote shows the start of the message with id replyTo the message
+// answers.
+func (b *messageBubble) setQuote(replyTo uint64, q *protocol.Quote) {
+	if replyTo == 0 || q == nil {
+		return
+	}
+
+	b.replyTo = replyTo
+	if q.Deleted {
+		b.quote.text.SetText("<" + q.Sender + "> " + deletedText)
+	} else {
+		b.quote.text.SetText("<" + q.Sender + "> " + q.Body)
+	}
+	b.quote.Show()
+}
+
+// withReactions returns the bubble with its reaction bar below it, aligned
+// to the right for the user's own messages.
+func (b *messageBubble) withReactions(isUser bool) *fyne.Container {
+	b.bar = container.NewHBox()
+	b.barRow = b.bar
+	if isUser {
+		b.barRow = container.NewHBox(layout.NewSpacer(), b.bar)
+	}
+	b.barRow.Hide()
+
+	return container.NewVBox(b.area, b.barRow)
+}
+
+// setReactions shows the reactions to the message, those of self
+// highlighted.
+func (b *messageBubble) setReactions(reactions []protocol.Reaction, self string) {
+	b.reactions = reactions
+
+	b.bar.Objects = nil
+	for _, r := range reactions {
+		btn := widget.NewButton(fmt.Sprintf("%s %d", r.Emoji, r.Count), func() {
+			if b.react != nil {
+				b.react(r.Emoji)
+			}
+		})
+		if b.reacted(r.Emoji, self) {
+			btn.Importance = widget.HighImportance
+		} else {
+			btn.Importance = widget.LowImportance
+		}
+		b.bar.Objects = append(b.bar.Objects, btn)
+	}
+	b.bar.Refresh()
+
+	if len(reactions) == 0 {
+		b.barRow.Hide()
+	} else {
+		b.barRow.Show()
+	}
+}
+
+// reacted reports whether user reacted to the message with emoji.
+func (b *messageBubble) reacted(emoji, user string) bool {
+	for _, r := range b.reactions {
+		if r.Emoji == emoji {
+			return slices.ContainsFunc(r.Users, func(u string) bool { return strings.EqualFold(u, user) })
+		}
+	}
+	return false
+}
+
+// messageArea is the visible part of a bubble, it opens the message menu on
+// a right click.
+type messageArea struct {
+	widget.BaseWidget
+	content fyne.CanvasObject
+	// menu returns the actions offered, nil for none
+	menu func() *fyne.Menu
+}
+
+func newMessageArea(content fyne.CanvasObject) *messageArea {
+	a := &messageArea{content: content}
+	a.ExtendBaseWidget(a)
+	return a
+}
+
+func (a *messageArea) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(a.content)
+}
+
+func (a *messageArea) TappedSecondary(ev *fyne.PointEvent) {
+	if a.menu == nil {
+		return
+	}
+
+	if menu := a.menu(); menu != nil {
+		widget.ShowPopUpMenuAtPosition(menu, fyne.CurrentApp().Driver().CanvasForObject(a), ev.AbsolutePosition)
+	}
+}
+
+// quoteArea shows the start of the message a reply answers, tapping it leads
+// there. It is hidden unless the message is a reply.
+type quoteArea struct {
+	widget.BaseWidget
+	text  *widget.Label
+	onTap func()
+}
+
+func newQuoteArea() *quoteArea {
+	q := &quoteArea{text: widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Italic: true})}
+	q.text.Truncation = fyne.TextTruncateEllipsis
+	q.ExtendBaseWidget(q)
+	q.Hide()
+	return q
+}
+
+func (q *quoteArea) CreateRenderer() fyne.WidgetRenderer {
+	line := canvas.NewRectangle(color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	line.SetMinSize(fyne.NewSize(3, 0))
+	return widget.NewSimpleRenderer(container.NewBorder(nil, nil, line, nil, q.text))
+}
+
+func (q *quoteArea) Tapped(*fyne.PointEvent) {
+	if q.onTap != nil {
+		q.onTap()
+	}
+}
+
+func (q *quoteArea) Cursor() desktop.Cursor {
+	return desktop.PointerCursor
+}
+
+// register keeps b to apply the edits and deletes of message id in a channel
+// to, and offers the message menu on it.
+func (cv *channelView) register(name string, id uint64, b *messageBubble) {
+	pane, ok := cv.panes[name]
+	if !ok || id == 0 {
+		return
+	}
+
+	if pane.messages == nil {
+		pane.messages = map[uint64]*messageBubble{}
+	}
+	b.id = id
+	pane.messages[id] = b
+
+	if b.replyTo != 0 {
+		if pane.replied == nil {
+			pane.replied = map[uint64]bool{}
+		}
+		pane.replied[b.replyTo] = true
+		b.quote.onTap = func() { cv.jumpTo(name, b.replyTo) }
+	}
+
+	if cv.messageMenu != nil {
+		b.area.menu = func() *fyne.Menu { return cv.messageMenu(name, b) }
+	}
+	if cv.onReact != nil {
+		b.react = func(emoji string) { cv.onReact(name, b, emoji) }
+	}
+}
+
+// await keeps an own message sent with ref until the server confirms it.
+func (cv *channelView) await(name, ref string, b *messageBubble) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	if pane.sending == nil {
+		pane.sending = map[string]*messageBubble{}
+	}
+	pane.sending[ref] = b
+}
+
+// confirm registers the own message sent with ref under the id the server
+// gave it.
+func (cv *channelView) confirm(name, ref string, id uint64) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	b, ok := pane.sending[ref]
+	if !ok {
+		return
+	}
+	delete(pane.sending, ref)
+
+	pane.track(id)
+	cv.register(name, id, b)
+}
+
+// edit replaces the text of message id in a channel. streaming is set while
+// a bot is still writing it, edited when someone changed it.
+func (cv *channelView) edit(name string, id uint64, body string, streaming, edited bool) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	b, ok := pane.messages[id]
+	if !ok {
+		return
+	}
+
+	// Follow a growing message only if the user hasn't scrolled away from it
+	atBottom := cv.scroll.Offset.Y+cv.scroll.Size().Height >= cv.scroll.Content.MinSize().Height-1
+	b.streaming = streaming
+	b.edit(body, edited)
+	cv.requote(pane, b)
+	if pane == cv.panes[cv.active] && atBottom {
+		cv.scroll.ScrollToBottom()
+	}
+}
+
+// inThread reports whether b replies to a message of a channel or has
+// replies.
+func (cv *channelView) inThread(name string, b *messageBubble) bool {
+	pane, ok := cv.panes[name]
+	return ok && (b.replyTo != 0 || pane.replied[b.id])
+}
+
+// jumpTo scrolls to message id of the channel shown. Messages that aren't
+// loaded are shown with their thread instead.
+func (cv *channelView) jumpTo(name string, id uint64) {
+	pane, ok := cv.panes[name]
+	if !ok || pane != cv.panes[cv.active] {
+		return
+	}
+
+	b, ok := pane.messages[id]
+	if !ok {
+		if cv.requestThread != nil {
+			cv.requestThread(name, id)
+		}
+		return
+	}
+
+	driver := fyne.CurrentApp().Driver()
+	y := driver.AbsolutePositionForObject(b.area).Y - driver.AbsolutePositionForObject(pane.area).Y
+	cv.scroll.ScrollToOffset(fyne.NewPos(0, max(y-theme.Padding()*4, 0)))
+}
+
+// setReactions shows the reactions to message id in a channel.
+func (cv *channelView) setReactions(name string, id uint64, reactions []protocol.Reaction, self string) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	if b, ok := pane.messages[id]; ok && !b.deleted {
+		b.setReactions(reactions, self)
+	}
+}
+
+// removeMessage tombstones message id in a channel.
+func (cv *channelView) removeMessage(name string, id uint64) {
+	pane, ok := cv.panes[name]
+	if !ok {
+		return
+	}
+
+	if b, ok := pane.messages[id]; ok {
+		b.remove()
+		cv.requote(pane, b)
+	}
+}
+
+// requote refreshes the quotes of the loaded replies to b after it changed.
+func (cv *channelView) requote(pane *channelPane, b *messageBubble) {
+	if !pane.replied[b.id] {
+		return
+	}
+
+	q := &protocol.Quote{Sender: b.sender, Deleted: true}
+	if !b.deleted {
+		q = protocol.NewQuote(b.sender, b.body)
+	}
+	for _, reply := range pane.messages {
+		if reply.replyTo == b.id && !reply.deleted {
+			reply.setQuote(b.id, q)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+)
+
+// prefPins keeps trust-on-first-use certificate pins in the app preferences,
+// one per server address.
+type prefPins struct {
+	prefs fyne.Preferences
+}
+
+func pinKey(address string) string {
+	return "pin:" + strings.ToLower(strings.TrimSpace(address))
+}
+
+func (p prefPins) Pin(address string) (string, bool) {
+	fingerprint := p.prefs.String(pinKey(address))
+	return fingerprint, fingerprint != ""
+}
+
+func (p prefPins) SetPin(address, fingerprint string) error {
+	p.prefs.SetString(pinKey(address), fingerprint)
+	return nil
+}
+
+func (p prefPins) forget(address string) {
+	p.prefs.RemoveValue(pinKey(address))
+}
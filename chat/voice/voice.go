@@ -1,14 +1,12 @@
-package utils
+// Package voice plays the client sound effects and connects to the LiveKit
+// voice room.
+package voice
 
 import (
 	"embed"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net"
-	"net/http"
-	"strings"
 	"time"
 
 	"github.com/faiface/beep"
@@ -26,44 +24,6 @@ var soundAssets embed.FS
 
 var room *lksdk.Room
 
-func EstablishConnection(displayName string, raw_addy string) (net.Conn, error) {
-	serverAddress := strings.TrimSpace(raw_addy) + ":8000"
-
-	conn, err := net.Dial("tcp", serverAddress)
-	if err != nil {
-		return nil, fmt.Errorf("error connecting to server: %q", err)
-	}
-
-	_, err = conn.Write([]byte(displayName + "\n"))
-	if err != nil {
-		return nil, fmt.Errorf("error sending display name to server: %q", err)
-	}
-
-	return conn, nil
-}
-
-func SendMessage(conn net.Conn, displayName string, msg string) error {
-	_, err := conn.Write([]byte(displayName + ": " + msg + "\n"))
-	if err != nil {
-		return fmt.Errorf("error sending message to server: %q", err)
-	}
-
-	return nil
-}
-
-func ExtractName(msg string) (t bool, name, text string) {
-	name_index := strings.Index(msg, ":")
-
-	if name_index != -1 {
-		name = msg[:name_index]
-		text = msg[name_index+2:]
-	} else {
-		return false, "", ""
-	}
-
-	return true, name, text
-}
-
 func PlaySound(sound string) {
 	f, err := soundAssets.Open(sound)
 	if err != nil {
@@ -84,30 +44,10 @@ func PlaySound(sound string) {
 	))
 }
 
-func StartVoice(roomName, identity, serverAddress string) error {
-	type tokenResponse struct {
-		JoinToken string `json:"jwtToken"`
-		HostUrl   string `json:"hostURL"`
-	}
-
-	url := fmt.Sprintf("http://%s:8080/token?name=%s", serverAddress, identity)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("token request failed: %q", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("token request failed: %q", body)
-	}
-
-	var tr tokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
-		return fmt.Errorf("failed to decode JSON: %w", err)
-	}
-
+// StartVoice joins a LiveKit room with a join token obtained from the
+// server's token endpoint and publishes the default microphone. Once
+// connected it never returns, RoomDisconnect leaves the room.
+func StartVoice(hostURL, joinToken, identity string) error {
 	if err := portaudio.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize port audio: %q", err)
 	}
@@ -119,7 +59,8 @@ func StartVoice(roomName, identity, serverAddress string) error {
 		},
 	}
 
-	room, err = lksdk.ConnectToRoomWithToken(tr.HostUrl, tr.JoinToken, roomCB)
+	var err error
+	room, err = lksdk.ConnectToRoomWithToken(hostURL, joinToken, roomCB)
 	if err != nil {
 		return fmt.Errorf("unable to connect to room: %q", err)
 	}
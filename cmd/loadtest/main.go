@@ -0,0 +1,281 @@
+// Command loadtest connects many clients to a chat server, has every one of
+// them send a burst of messages to #general and checks that each client
+// received every other client's messages exactly once and in order. It exits
+// non-zero if anything was lost, duplicated or reordered.
+//
+// Without -server it starts an in-process server on a random port.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+type options struct {
+	server   string
+	port     string
+	clients  int
+	messages int
+	rate     float64
+	timeout  time.Duration
+	tls      bool
+	queue    int
+}
+
+// result is what one client observed.
+type result struct {
+	// next is the sequence number expected from each sender
+	next      map[string]int
+	received  int
+	problems  []string
+	latencies []time.Duration
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.server, "server", "", "server address, empty starts an in-process server")
+	flag.StringVar(&opts.port, "port", client.DefaultChatPort, "chat port of -server")
+	flag.BoolVar(&opts.tls, "tls", false, "connect to -server over TLS")
+	flag.IntVar(&opts.clients, "clients", 20, "number of clients")
+	flag.IntVar(&opts.messages, "messages", 100, "messages sent by each client")
+	flag.Float64Var(&opts.rate, "rate", 50, "messages per second per client, 0 sends as fast as possible")
+	flag.DurationVar(&opts.timeout, "timeout", time.Minute, "time allowed for all messages to arrive")
+	flag.IntVar(&opts.queue, "queue", 0, "send queue size of the in-process server, 0 keeps the default")
+	flag.Parse()
+
+	if opts.server == "" {
+		srv, port, err := startServer(opts.queue)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer srv.Shutdown(context.Background())
+		opts.server, opts.port = "127.0.0.1", port
+	}
+
+	if !run(opts) {
+		os.Exit(1)
+	}
+}
+
+// startServer runs a server with the default config, and queues of queue
+// frames if it isn't 0. Clients that fall behind are disconnected and
+// reported, raise -queue or lower -rate to measure throughput alone.
+func startServer(queue int) (*server.Server, string, error) {
+	cfg := server.DefaultConfig()
+	cfg.TCPAddr = "127.0.0.1:0"
+	cfg.TokenAddr = ""
+	if queue > 0 {
+		cfg.SendQueueSize = queue
+	}
+
+	srv := server.New(cfg)
+	if err := srv.Start(context.Background()); err != nil {
+		return nil, "", err
+	}
+
+	_, port, err := net.SplitHostPort(srv.Addr().String())
+	return srv, port, err
+}
+
+func run(opts options) bool {
+	prefix := randomPrefix()
+	password := prefix + "-password"
+
+	fmt.Printf("connecting %d clients to %s:%s...\n", opts.clients, opts.server, opts.port)
+
+	clients := make([]*client.Client, opts.clients)
+	for i := range clients {
+		c := client.New(client.Config{
+			DisplayName:   fmt.Sprintf("%s-%d", prefix, i),
+			ServerAddress: opts.server,
+			ChatPort:      opts.port,
+			Password:      password,
+			Register:      true,
+			TLS:           opts.tls,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := c.Connect(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("client %d: %v\n", i, err)
+			return false
+		}
+		defer c.Close()
+
+		clients[i] = c
+	}
+
+	expected := (opts.clients - 1) * opts.messages
+	results := make([]*result, opts.clients)
+	ready := sync.WaitGroup{}
+	done := sync.WaitGroup{}
+	deadline := time.Now().Add(opts.timeout)
+
+	for i, c := range clients {
+		results[i] = &result{next: map[string]int{}}
+		ready.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			receive(c, prefix, expected, results[i], &ready, deadline)
+		}()
+	}
+	// Everyone has to be in the channel before the first message goes out
+	ready.Wait()
+
+	fmt.Printf("sending %d messages each...\n", opts.messages)
+	start := time.Now()
+
+	var senders sync.WaitGroup
+	for _, c := range clients {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			send(c, opts)
+		}()
+	}
+	senders.Wait()
+	sent := time.Since(start)
+
+	done.Wait()
+	elapsed := time.Since(start)
+
+	return report(opts, results, expected, sent, elapsed)
+}
+
+func send(c *client.Client, opts options) {
+	var tick *time.Ticker
+	if opts.rate > 0 {
+		tick = time.NewTicker(time.Duration(float64(time.Second) / opts.rate))
+		defer tick.Stop()
+	}
+
+	for seq := 0; seq < opts.messages; seq++ {
+		if tick != nil {
+			<-tick.C
+		}
+
+		body := fmt.Sprintf("load %d %d", seq, time.Now().UnixNano())
+		if err := c.Send(protocol.DefaultChannel, body); err != nil {
+			fmt.Printf("%s: %v\n", c.DisplayName(), err)
+			return
+		}
+	}
+}
+
+// receive counts the load messages arriving at c until all expected ones are
+// in or the deadline passes. ready is released once the initial history
+// replay arrived.
+func receive(c *client.Client, prefix string, expected int, res *result, ready *sync.WaitGroup, deadline time.Time) {
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			ready.Done()
+		}
+	}
+	defer release()
+
+	for res.received < expected {
+		select {
+		case env, ok := <-c.Messages():
+			if !ok {
+				res.problems = append(res.problems, "connection closed")
+				return
+			}
+
+			if env.Type == protocol.TypeHistory {
+				release()
+				continue
+			}
+			if env.Type == protocol.TypeGoodbye {
+				res.problems = append(res.problems, fmt.Sprintf("%s: disconnected with %d of %d messages: %s", c.DisplayName(), res.received, expected, env.Reason))
+				return
+			}
+			if env.Type != protocol.TypeChat || !strings.HasPrefix(env.Sender, prefix) {
+				continue
+			}
+
+			fields := strings.Fields(env.Body)
+			if len(fields) != 3 || fields[0] != "load" {
+				continue
+			}
+			seq, _ := strconv.Atoi(fields[1])
+			sentAt, _ := strconv.ParseInt(fields[2], 10, 64)
+
+			if want := res.next[env.Sender]; seq != want {
+				res.problems = append(res.problems, fmt.Sprintf("%s: got #%d from %s, expected #%d", c.DisplayName(), seq, env.Sender, want))
+			}
+			res.next[env.Sender] = seq + 1
+			res.received++
+			res.latencies = append(res.latencies, time.Since(time.Unix(0, sentAt)))
+
+		case <-timeout.C:
+			res.problems = append(res.problems, fmt.Sprintf("%s: timed out with %d of %d messages", c.DisplayName(), res.received, expected))
+			return
+		}
+	}
+}
+
+func report(opts options, results []*result, expected int, sent, elapsed time.Duration) bool {
+	var latencies []time.Duration
+	var problems []string
+	delivered := 0
+
+	for _, res := range results {
+		delivered += res.received
+		latencies = append(latencies, res.latencies...)
+		problems = append(problems, res.problems...)
+	}
+	slices.Sort(latencies)
+
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+
+	total := opts.clients * opts.messages
+	fmt.Printf("sent      %d messages in %s (%.0f msg/s)\n", total, sent.Round(time.Millisecond), float64(total)/sent.Seconds())
+	fmt.Printf("delivered %d of %d in %s (%.0f msg/s)\n", delivered, expected*opts.clients, elapsed.Round(time.Millisecond), float64(delivered)/elapsed.Seconds())
+	fmt.Printf("latency   p50 %s  p99 %s  max %s\n", percentile(0.5).Round(time.Microsecond), percentile(0.99).Round(time.Microsecond), percentile(1).Round(time.Microsecond))
+
+	if len(problems) > 0 {
+		for _, p := range problems[:min(len(problems), 20)] {
+			fmt.Println(p)
+		}
+		fmt.Printf("FAIL: %d problems\n", len(problems))
+		return false
+	}
+
+	fmt.Println("PASS")
+	return true
+}
+
+// randomPrefix keeps account names of separate runs against the same server
+// from colliding.
+func randomPrefix() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return "load" + hex.EncodeToString(b)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/server"
+	"github.com/joho/godotenv"
+)
+
+// config is everything an operator can set. Values are layered: built in
+// defaults, then the JSON config file, then environment variables (a .env
+// file only fills in variables that aren't set already), then flags.
+type config struct {
+	ChatAddr    string `json:"chatAddr"`
+	TokenAddr   string `json:"tokenAddr"`
+	RoomName    string `json:"roomName"`
+	HistoryFile string `json:"historyFile"`
+	HistorySize int    `json:"historySize"`
+
+	AccountsFile string `json:"accountsFile"`
+
+	TLSCertFile   string `json:"tlsCertFile"`
+	TLSKeyFile    string `json:"tlsKeyFile"`
+	TLSSelfSigned bool   `json:"tlsSelfSigned"`
+
+	// ShutdownTimeout is how long clients get to disconnect on SIGINT/SIGTERM
+	ShutdownTimeout duration `json:"shutdownTimeout"`
+
+	SendQueueSize int      `json:"sendQueueSize"`
+	WriteTimeout  duration `json:"writeTimeout"`
+	SlowConsumer  string   `json:"slowConsumer"`
+
+	MaxMessageSize int `json:"maxMessageSize"`
+
+	// Admins may run admin only #commands
+	Admins []string `json:"admins"`
+	// Moderators may edit and delete anyone's messages
+	Moderators []string `json:"moderators"`
+
+	LiveKit struct {
+		URL       string `json:"url"`
+		APIKey    string `json:"apiKey"`
+		APISecret string `json:"apiSecret"`
+	} `json:"livekit"`
+
+	AI struct {
+		APIKey       string `json:"apiKey"`
+		BaseURL      string `json:"baseURL"`
+		Model        string `json:"model"`
+		SystemPrompt string `json:"systemPrompt"`
+		MaxTurns     int    `json:"maxTurns"`
+		MaxTokens    int    `json:"maxTokens"`
+
+		// Timeout is how long a bot may take to answer, the rates are prompts
+		// per minute and the token budgets per day, zero means no limit
+		Timeout         duration `json:"timeout"`
+		UserRate        int      `json:"userRate"`
+		GlobalRate      int      `json:"globalRate"`
+		UserDailyTokens int      `json:"userDailyTokens"`
+		DailyTokens     int      `json:"dailyTokens"`
+
+		Personas []persona `json:"personas"`
+	} `json:"ai"`
+}
+
+// persona is a bot of the server, fields left empty use the ai defaults.
+type persona struct {
+	Name         string   `json:"name"`
+	Command      string   `json:"command"`
+	SystemPrompt string   `json:"systemPrompt"`
+	Model        string   `json:"model"`
+	Temperature  *float64 `json:"temperature"`
+}
+
+func defaultConfig() *config {
+	def := server.DefaultConfig()
+
+	cfg := &config{
+		ChatAddr:     def.TCPAddr,
+		TokenAddr:    def.TokenAddr,
+		RoomName:     def.RoomName,
+		HistoryFile:  "history.log",
+		HistorySize:  def.HistorySize,
+		AccountsFile: "accounts.json",
+
+		ShutdownTimeout: duration{10 * time.Second},
+
+		SendQueueSize: def.SendQueueSize,
+		WriteTimeout:  duration{def.WriteTimeout},
+		SlowConsumer:  string(def.SlowConsumer),
+
+		MaxMessageSize: def.MaxMessageSize,
+	}
+	cfg.AI.Model = def.AIModel
+	cfg.AI.SystemPrompt = def.AISystemPrompt
+	cfg.AI.MaxTurns = def.AIMaxTurns
+	cfg.AI.MaxTokens = def.AIMaxTokens
+	cfg.AI.Timeout = duration{def.AITimeout}
+	cfg.AI.UserRate = def.AIUserRate
+	cfg.AI.GlobalRate = def.AIGlobalRate
+	cfg.AI.UserDailyTokens = def.AIUserDailyTokens
+	cfg.AI.DailyTokens = def.AIDailyTokens
+
+	return cfg
+}
+
+// loadConfig builds the configuration from the command line arguments.
+func loadConfig(args []string) (*config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", "config.json", "JSON config file, skipped if the default one is missing")
+	envFile := fs.String("env", ".env", "file with environment variables, skipped if missing")
+
+	// Flags are bound to a scratch copy and only applied if set, so they win
+	// over the file and environment without resetting them to defaults.
+	// Secrets have no flags on purpose, command lines are visible to everyone.
+	flags := *cfg
+	fs.StringVar(&flags.ChatAddr, "addr", cfg.ChatAddr, "chat listen address")
+	fs.StringVar(&flags.TokenAddr, "token-addr", cfg.TokenAddr, "voice token endpoint listen address, empty disables it")
+	fs.StringVar(&flags.RoomName, "room", cfg.RoomName, "LiveKit room name of the default channel")
+	fs.StringVar(&flags.HistoryFile, "history-file", cfg.HistoryFile, "message history log")
+	fs.IntVar(&flags.HistorySize, "history-size", cfg.HistorySize, "messages replayed when joining a channel")
+	fs.StringVar(&flags.AccountsFile, "accounts-file", cfg.AccountsFile, "registered accounts file")
+	fs.StringVar(&flags.TLSCertFile, "tls-cert", cfg.TLSCertFile, "TLS certificate file")
+	fs.StringVar(&flags.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "TLS key file")
+	fs.BoolVar(&flags.TLSSelfSigned, "tls-self-signed", cfg.TLSSelfSigned, "generate a self-signed certificate if none exists")
+	fs.DurationVar(&flags.ShutdownTimeout.Duration, "shutdown-timeout", cfg.ShutdownTimeout.Duration, "time clients get to disconnect when the server stops")
+	fs.IntVar(&flags.SendQueueSize, "send-queue", cfg.SendQueueSize, "frames queued per client before the slow consumer policy applies")
+	fs.DurationVar(&flags.WriteTimeout.Duration, "write-timeout", cfg.WriteTimeout.Duration, "time a single write to a client may take")
+	fs.StringVar(&flags.SlowConsumer, "slow-consumer", cfg.SlowConsumer, "what to do with clients that fall behind: disconnect or drop-oldest")
+	fs.IntVar(&flags.MaxMessageSize, "max-message-size", cfg.MaxMessageSize, "largest message in bytes a client may send")
+	fs.StringVar(&flags.LiveKit.URL, "livekit-url", cfg.LiveKit.URL, "LiveKit server URL")
+	fs.StringVar(&flags.AI.Model, "ai-model", cfg.AI.Model, "default chat completion model of the bots")
+	fs.StringVar(&flags.AI.BaseURL, "ai-base-url", cfg.AI.BaseURL, "OpenAI compatible endpoint, empty uses api.openai.com")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if err := cfg.readFile(*configFile, set["config"]); err != nil {
+		return nil, err
+	}
+
+	if err := godotenv.Load(*envFile); err != nil && (set["env"] || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("error loading %s: %w", *envFile, err)
+	}
+
+	if err := cfg.readEnv(); err != nil {
+		return nil, err
+	}
+
+	apply := map[string]func(){
+		"addr":             func() { cfg.ChatAddr = flags.ChatAddr },
+		"token-addr":       func() { cfg.TokenAddr = flags.TokenAddr },
+		"room":             func() { cfg.RoomName = flags.RoomName },
+		"history-file":     func() { cfg.HistoryFile = flags.HistoryFile },
+		"history-size":     func() { cfg.HistorySize = flags.HistorySize },
+		"accounts-file":    func() { cfg.AccountsFile = flags.AccountsFile },
+		"tls-cert":         func() { cfg.TLSCertFile = flags.TLSCertFile },
+		"tls-key":          func() { cfg.TLSKeyFile = flags.TLSKeyFile },
+		"tls-self-signed":  func() { cfg.TLSSelfSigned = flags.TLSSelfSigned },
+		"shutdown-timeout": func() { cfg.ShutdownTimeout = flags.ShutdownTimeout },
+		"send-queue":       func() { cfg.SendQueueSize = flags.SendQueueSize },
+		"write-timeout":    func() { cfg.WriteTimeout = flags.WriteTimeout },
+		"slow-consumer":    func() { cfg.SlowConsumer = flags.SlowConsumer },
+		"max-message-size": func() { cfg.MaxMessageSize = flags.MaxMessageSize },
+		"livekit-url":      func() { cfg.LiveKit.URL = flags.LiveKit.URL },
+		"ai-model":         func() { cfg.AI.Model = flags.AI.Model },
+		"ai-base-url":      func() { cfg.AI.BaseURL = flags.AI.BaseURL },
+	}
+	for name := range set {
+		if f, ok := apply[name]; ok {
+			f()
+		}
+	}
+
+	switch server.SlowConsumerPolicy(cfg.SlowConsumer) {
+	case server.SlowConsumerDisconnect, server.SlowConsumerDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown slow consumer policy %q, use %q or %q", cfg.SlowConsumer, server.SlowConsumerDisconnect, server.SlowConsumerDropOldest)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range cfg.AI.Personas {
+		key := strings.ToLower(p.Name)
+		if key == "" {
+			return nil, errors.New("every ai persona needs a name")
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("ai persona %q is defined twice", p.Name)
+		}
+		seen[key] = true
+	}
+
+	// A self-signed certificate is kept next to the server unless told otherwise
+	if cfg.TLSSelfSigned && cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		cfg.TLSCertFile, cfg.TLSKeyFile = "tls.crt", "tls.key"
+	}
+
+	return cfg, nil
+}
+
+// readFile merges the JSON file at path into cfg. A missing file is only an
+// error if it was asked for explicitly.
+func (cfg *config) readFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// readEnv overrides cfg with every variable that is set.
+func (cfg *config) readEnv() error {
+	strs := map[string]*string{
+		"CHAT_ADDR":          &cfg.ChatAddr,
+		"TOKEN_ADDR":         &cfg.TokenAddr,
+		"ROOM_NAME":          &cfg.RoomName,
+		"HISTORY_FILE":       &cfg.HistoryFile,
+		"ACCOUNTS_FILE":      &cfg.AccountsFile,
+		"TLS_CERT_FILE":      &cfg.TLSCertFile,
+		"TLS_KEY_FILE":       &cfg.TLSKeyFile,
+		"LIVEKIT_URL":        &cfg.LiveKit.URL,
+		"LIVEKIT_API_KEY":    &cfg.LiveKit.APIKey,
+		"LIVEKIT_API_SECRET": &cfg.LiveKit.APISecret,
+		"OPENAI_API_KEY":     &cfg.AI.APIKey,
+		"OPENAI_MODEL":       &cfg.AI.Model,
+		"AI_BASE_URL":        &cfg.AI.BaseURL,
+		"AI_SYSTEM_PROMPT":   &cfg.AI.SystemPrompt,
+		"SLOW_CONSUMER":      &cfg.SlowConsumer,
+	}
+	for name, dst := range strs {
+		if val, ok := os.LookupEnv(name); ok {
+			*dst = val
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout.Duration,
+		"WRITE_TIMEOUT":    &cfg.WriteTimeout.Duration,
+		"AI_TIMEOUT":       &cfg.AI.Timeout.Duration,
+	}
+	for name, dst := range durations {
+		if val, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = d
+		}
+	}
+
+	ints := map[string]*int{
+		"HISTORY_SIZE":         &cfg.HistorySize,
+		"SEND_QUEUE_SIZE":      &cfg.SendQueueSize,
+		"MAX_MESSAGE_SIZE":     &cfg.MaxMessageSize,
+		"AI_MAX_TURNS":         &cfg.AI.MaxTurns,
+		"AI_MAX_TOKENS":        &cfg.AI.MaxTokens,
+		"AI_USER_RATE":         &cfg.AI.UserRate,
+		"AI_GLOBAL_RATE":       &cfg.AI.GlobalRate,
+		"AI_USER_DAILY_TOKENS": &cfg.AI.UserDailyTokens,
+		"AI_DAILY_TOKENS":      &cfg.AI.DailyTokens,
+	}
+	for name, dst := range ints {
+		if val, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+
+	lists := map[string]*[]string{
+		"ADMINS":     &cfg.Admins,
+		"MODERATORS": &cfg.Moderators,
+	}
+	for name, dst := range lists {
+		if val, ok := os.LookupEnv(name); ok {
+			*dst = nil
+			for _, account := range strings.Split(val, ",") {
+				if account = strings.TrimSpace(account); account != "" {
+					*dst = append(*dst, account)
+				}
+			}
+		}
+	}
+
+	if val, ok := os.LookupEnv("TLS_SELF_SIGNED"); ok {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("TLS_SELF_SIGNED: %w", err)
+		}
+		cfg.TLSSelfSigned = b
+	}
+
+	return nil
+}
+
+// serverConfig turns cfg into a server.Config, opening the stores it names.
+func (cfg *config) serverConfig() (server.Config, error) {
+	sc := server.DefaultConfig()
+
+	sc.TCPAddr = cfg.ChatAddr
+	sc.TokenAddr = cfg.TokenAddr
+	sc.RoomName = cfg.RoomName
+	sc.HistorySize = cfg.HistorySize
+	sc.TLSCertFile = cfg.TLSCertFile
+	sc.TLSKeyFile = cfg.TLSKeyFile
+	sc.TLSSelfSigned = cfg.TLSSelfSigned
+	sc.LiveKitURL = cfg.LiveKit.URL
+	sc.LiveKitAPIKey = cfg.LiveKit.APIKey
+	sc.LiveKitAPISecret = cfg.LiveKit.APISecret
+	sc.SendQueueSize = cfg.SendQueueSize
+	sc.WriteTimeout = cfg.WriteTimeout.Duration
+	sc.SlowConsumer = server.SlowConsumerPolicy(cfg.SlowConsumer)
+	sc.MaxMessageSize = cfg.MaxMessageSize
+	sc.Admins = cfg.Admins
+	sc.Moderators = cfg.Moderators
+	sc.OpenAIKey = cfg.AI.APIKey
+	sc.AIModel = cfg.AI.Model
+	sc.AISystemPrompt = cfg.AI.SystemPrompt
+	sc.AIMaxTurns = cfg.AI.MaxTurns
+	sc.AIMaxTokens = cfg.AI.MaxTokens
+	sc.AITimeout = cfg.AI.Timeout.Duration
+	sc.AIUserRate = cfg.AI.UserRate
+	sc.AIGlobalRate = cfg.AI.GlobalRate
+	sc.AIUserDailyTokens = cfg.AI.UserDailyTokens
+	sc.AIDailyTokens = cfg.AI.DailyTokens
+	sc.AIBaseURL = cfg.AI.BaseURL
+	for _, p := range cfg.AI.Personas {
+		sc.Personas = append(sc.Personas, server.Persona{
+			Name:         p.Name,
+			Command:      p.Command,
+			SystemPrompt: p.SystemPrompt,
+			Model:        p.Model,
+			Temperature:  p.Temperature,
+		})
+	}
+
+	store, err := server.OpenFileStore(cfg.HistoryFile)
+	if err != nil {
+		return sc, err
+	}
+	sc.Store = store
+
+	accounts, err := server.OpenFileAccounts(cfg.AccountsFile)
+	if err != nil {
+		store.Close()
+		return sc, err
+	}
+	sc.Accounts = accounts
+
+	return sc, nil
+}
+
+// duration is a time.Duration written as a string like "10s" in the config
+// file.
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+
+	return nil
+}
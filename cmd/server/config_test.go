@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReadEnvInts(t *testing.T) {
+	cfg := defaultConfig()
+
+	tests := []struct {
+		name string
+		dst  *int
+	}{
+		{"HISTORY_SIZE", &cfg.HistorySize},
+		{"SEND_QUEUE_SIZE", &cfg.SendQueueSize},
+		{"MAX_MESSAGE_SIZE", &cfg.MaxMessageSize},
+		{"AI_MAX_TURNS", &cfg.AI.MaxTurns},
+		{"AI_MAX_TOKENS", &cfg.AI.MaxTokens},
+		{"AI_USER_RATE", &cfg.AI.UserRate},
+		{"AI_GLOBAL_RATE", &cfg.AI.GlobalRate},
+		{"AI_USER_DAILY_TOKENS", &cfg.AI.UserDailyTokens},
+		{"AI_DAILY_TOKENS", &cfg.AI.DailyTokens},
+	}
+	for i, tt := range tests {
+		t.Setenv(tt.name, strconv.Itoa(1000+i))
+	}
+
+	if err := cfg.readEnv(); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		if *tt.dst != 1000+i {
+			t.Errorf("%s = %d, want %d", tt.name, *tt.dst, 1000+i)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Setenv(tt.name, "many")
+		if err := cfg.readEnv(); err == nil {
+			t.Errorf("%s=many accepted", tt.name)
+		}
+		t.Setenv(tt.name, "1")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	sc, err := cfg.serverConfig()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := server.New(sc)
+	if err := srv.Start(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	<-ctx.Done()
+	// A second signal kills the process right away
+	stop()
+
+	fmt.Printf("\nShutting down, waiting up to %s for clients to disconnect...\n", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
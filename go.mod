@@ -12,6 +12,7 @@ require (
 	github.com/livekit/server-sdk-go/v2 v2.9.1
 	github.com/openai/openai-go v1.6.0
 	github.com/pion/webrtc/v4 v4.1.2
+	golang.org/x/crypto v0.38.0
 	gopkg.in/hraban/opus.v2 v2.0.0-20230925203106-0188a62cb302
 )
 
@@ -103,7 +104,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
 	go.uber.org/zap/exp v0.3.0 // indirect
-	golang.org/x/crypto v0.38.0 // indirect
 	golang.org/x/exp v0.0.0-20250506013437-ce4c2cf36ca6 // indirect
 	golang.org/x/exp/shiny v0.0.0-20230817173708-d852ddb80c63 // indirect
 	golang.org/x/image v0.24.0 // indirect
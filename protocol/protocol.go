@@ -0,0 +1,427 @@
+// Package protocol defines the framed wire format spoken between the chat
+// server and its clients.
+//
+// Every frame is a 4 byte big-endian length followed by that many bytes of
+// JSON encoding a single Envelope. A session starts with the client sending a
+// Hello frame and the server answering with Welcome (or Error) before any
+// other traffic is exchanged.
+package protocol
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Version is the protocol version sent in the handshake. The server rejects
+// clients speaking a different version.
+const Version = 1
+
+// MaxFrameSize is the largest frame payload a Decoder will accept.
+const MaxFrameSize = 64 * 1024
+
+// Type identifies what an Envelope carries.
+type Type string
+
+const (
+	// TypeHello is the first frame a client sends, Sender is the account name
+	// and Auth holds its credentials
+	TypeHello Type = "hello"
+	// TypeWelcome acknowledges a Hello, Sender is the account name as
+	// registered and Auth.Token a token usable for later logins
+	TypeWelcome Type = "welcome"
+	// TypeChat is a message typed by a user
+	TypeChat Type = "chat"
+	// TypeNotice is a message generated by the server (joins, leaves, command output)
+	TypeNotice Type = "notice"
+	// TypeError reports a problem with the previous request
+	TypeError Type = "error"
+	// TypeJoined tells a client it is now a member of Room
+	TypeJoined Type = "joined"
+	// TypeLeft tells a client it is no longer a member of Room
+	TypeLeft Type = "left"
+	// TypeHistory carries stored messages of Room, oldest first. A client
+	// sends it with ID set to the oldest id it has to page further back, the
+	// reply echoes that ID. Replays the client did not ask for have ID 0.
+	TypeHistory Type = "history"
+	// TypeDirect is a private message from Sender to the user named in To. It
+	// is never stored and only delivered to the connections of both users.
+	TypeDirect Type = "direct"
+	// TypeGoodbye is the last frame the server sends before closing the
+	// connection, Reason says why and Body is a message for the user
+	TypeGoodbye Type = "goodbye"
+	// TypeEdit replaces the Body of the earlier message with ID in Room.
+	// Clients send it to edit a message, the server passes it on with Sender
+	// set to who edited it and Edited set.
+	TypeEdit Type = "edit"
+	// TypeDelete removes the message with ID in Room, clients show that it
+	// was deleted. Like TypeEdit it is sent by clients and passed on with
+	// Sender set to who deleted it.
+	TypeDelete Type = "delete"
+	// TypeSent confirms a chat message to the connection that sent it, with
+	// the ID the server gave it and the Ref the client gave it
+	TypeSent Type = "sent"
+	// TypeThinking tells the members of Room that the bot in Sender is
+	// working on an answer while Active is set, and stopped once it is not
+	TypeThinking Type = "thinking"
+	// TypePresence is the Status of Sender in Room and whether it is in the
+	// voice room of Room. Clients send it without Sender to change their
+	// own, with Voice set and Room naming the channel whose voice room they
+	// joined.
+	TypePresence Type = "presence"
+	// TypeMembers lists everyone in Room, sent right after TypeJoined
+	TypeMembers Type = "members"
+	// TypeTyping tells Room, or the user in To for a direct conversation,
+	// that Sender is writing a message while Active is set and stopped once
+	// it is not. It is never stored.
+	TypeTyping Type = "typing"
+	// TypeReact adds the Emoji reaction of its sender to the message with ID
+	// in Room while Active is set, and takes it back when it is not. The
+	// server passes it on with Sender set and Reactions holding every
+	// reaction to the message.
+	TypeReact Type = "react"
+	// TypeThread asks for the thread the message with ID in Room is part
+	// of. The server answers with the same type, ID set to the first message
+	// of the thread and History holding it and all its replies.
+	TypeThread Type = "thread"
+)
+
+// TypingTimeout is how long a typing indicator is shown unless it is renewed.
+// Clients renew it every TypingTimeout/2 while the user keeps typing.
+const TypingTimeout = 6 * time.Second
+
+// Status is whether a user is around, carried by TypePresence frames.
+type Status string
+
+const (
+	StatusOnline Status = "online"
+	StatusAway   Status = "away"
+	// StatusOffline means Sender is no longer in Room, because it
+	// disconnected or left the channel
+	StatusOffline Status = "offline"
+)
+
+// Member is one user in a channel's member list.
+type Member struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// Voice is set while the user is in the channel's voice room
+	Voice bool `json:"voice,omitempty"`
+}
+
+// Reaction is one emoji reacted to a message with and who did.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// quoteLength is how many characters of a message a Quote keeps.
+const quoteLength = 100
+
+// Quote is the start of the message a reply answers.
+type Quote struct {
+	Sender string `json:"sender"`
+	Body   string `json:"body"`
+	// Deleted is set once the message answered was deleted, Body is empty
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// NewQuote returns the quote of a message by sender, with body cut short.
+func NewQuote(sender, body string) *Quote {
+	body = strings.Join(strings.Fields(body), " ")
+	if runes := []rune(body); len(runes) > quoteLength {
+		body = string(runes[:quoteLength-1]) + "…"
+	}
+	return &Quote{Sender: sender, Body: body}
+}
+
+// Reason is the machine readable cause carried by a TypeGoodbye frame.
+type Reason string
+
+const (
+	// ReasonShutdown means the server is stopping
+	ReasonShutdown Reason = "shutdown"
+	// ReasonSlowConsumer means the client did not read fast enough and its
+	// outbound queue overflowed
+	ReasonSlowConsumer Reason = "slow_consumer"
+)
+
+// DefaultChannel is the channel every client joins after the handshake. An
+// empty Room on the wire means DefaultChannel.
+const DefaultChannel = "general"
+
+// Sender names used by the server for messages it generates itself.
+const (
+	SenderServer = "Server"
+	SenderAI     = "AI"
+)
+
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// FrameTooLargeError is returned by Decode for a frame over the decoder's
+// limit. The frame has been skipped, so the next Decode continues with the
+// frame after it. It matches ErrFrameTooLarge with errors.Is.
+type FrameTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds maximum size of %d", e.Size, e.Max)
+}
+
+func (e *FrameTooLargeError) Is(target error) bool {
+	return target == ErrFrameTooLarge
+}
+
+// Envelope is the unit of every exchange on the wire.
+type Envelope struct {
+	Version int  `json:"v,omitempty"`
+	Type    Type `json:"type"`
+	// ID is given to stored chat messages only, other frames refer to one
+	// with it
+	ID     uint64    `json:"id,omitempty"`
+	Sender string    `json:"sender,omitempty"`
+	Room   string    `json:"room,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Time   time.Time `json:"ts"`
+	Body   string    `json:"body,omitempty"`
+
+	// History and More are only set on TypeHistory frames
+	History []*Envelope `json:"history,omitempty"`
+	More    bool        `json:"more,omitempty"`
+
+	// Reason is only set on TypeGoodbye frames
+	Reason Reason `json:"reason,omitempty"`
+
+	// Auth is only set on TypeHello and TypeWelcome frames
+	Auth *Auth `json:"auth,omitempty"`
+	// Resume is only set on a TypeHello sent when reconnecting
+	Resume *Resume `json:"resume,omitempty"`
+	// MaxMessageSize is only set on TypeWelcome, the largest Body in bytes
+	// the server accepts
+	MaxMessageSize int `json:"maxMessageSize,omitempty"`
+	// Moderator is only set on TypeWelcome, for an account that may edit
+	// and delete anyone's messages
+	Moderator bool `json:"moderator,omitempty"`
+	// Ref is a client chosen reference of a chat message it sends, echoed in
+	// the TypeSent confirming it
+	Ref string `json:"ref,omitempty"`
+	// Edited marks a message changed after it was sent, Deleted a stored
+	// message that was deleted and has no Body anymore
+	Edited  bool `json:"edited,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+	// Ephemeral marks a notice only its recipient got, such as a command
+	// reply. It is never stored.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Bot marks a chat message written by one of the server's AI personas
+	Bot bool `json:"bot,omitempty"`
+	// Streaming is set on a bot message and its edits while the answer is
+	// still being written, the last edit has it cleared
+	Streaming bool `json:"streaming,omitempty"`
+	// Active is only set on TypeThinking, TypeTyping and TypeReact frames
+	Active bool `json:"active,omitempty"`
+	// ReplyTo is the ID of the message a chat message answers. Only ReplyTo
+	// is stored, the server adds Quote from the message as it is when sending
+	ReplyTo uint64 `json:"replyTo,omitempty"`
+	Quote   *Quote `json:"quote,omitempty"`
+	// Emoji is only set on TypeReact frames
+	Emoji string `json:"emoji,omitempty"`
+	// Reactions are those to a stored chat message, also set on the
+	// TypeReact frames the server sends
+	Reactions []Reaction `json:"reactions,omitempty"`
+	// Status and Voice are only set on TypePresence frames
+	Status Status `json:"status,omitempty"`
+	Voice  bool   `json:"voice,omitempty"`
+	// Members is only set on TypeMembers frames
+	Members []Member `json:"members,omitempty"`
+}
+
+// Resume picks up a session after a dropped connection. The server rejoins
+// Channels and replays every stored message newer than LastID instead of the
+// usual latest page.
+type Resume struct {
+	LastID   uint64   `json:"lastId"`
+	Channels []string `json:"channels,omitempty"`
+	// Status and Voice restore the presence the client had, Voice names the
+	// channel whose voice room it is in
+	Status Status `json:"status,omitempty"`
+	Voice  string `json:"voice,omitempty"`
+}
+
+// Auth carries login credentials. A hello sets either Password or Token,
+// Register creates the account with Password first.
+type Auth struct {
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Register bool   `json:"register,omitempty"`
+}
+
+// NewHello returns the handshake frame for the account name.
+func NewHello(name string, auth *Auth) *Envelope {
+	return &Envelope{Version: Version, Type: TypeHello, Sender: name, Auth: auth, Time: time.Now()}
+}
+
+// NewChat returns a chat message from sender to room.
+func NewChat(room, sender, body string) *Envelope {
+	return &Envelope{Type: TypeChat, Sender: sender, Room: room, Body: body, Time: time.Now()}
+}
+
+// NewDirect returns a private message from sender to the user to.
+func NewDirect(sender, to, body string) *Envelope {
+	return &Envelope{Type: TypeDirect, Sender: sender, To: to, Body: body, Time: time.Now()}
+}
+
+// NewEdit returns an update of the message with id in room.
+func NewEdit(room string, id uint64, body string) *Envelope {
+	return &Envelope{Type: TypeEdit, ID: id, Room: room, Body: body, Time: time.Now()}
+}
+
+// NewDelete returns the removal of the message with id in room.
+func NewDelete(room string, id uint64) *Envelope {
+	return &Envelope{Type: TypeDelete, ID: id, Room: room, Time: time.Now()}
+}
+
+// NewReact returns the reaction with emoji to the message with id in room,
+// or taking it back when active is not set.
+func NewReact(room string, id uint64, emoji string, active bool) *Envelope {
+	return &Envelope{Type: TypeReact, ID: id, Room: room, Emoji: emoji, Active: active, Time: time.Now()}
+}
+
+// NewThinking returns the frame telling room whether the bot sender is
+// working on an answer.
+func NewThinking(room, sender string, active bool) *Envelope {
+	return &Envelope{Type: TypeThinking, Sender: sender, Room: room, Active: active, Time: time.Now()}
+}
+
+// NewPresence returns the frame telling room about the presence of m.
+func NewPresence(room string, m Member) *Envelope {
+	return &Envelope{Type: TypePresence, Sender: m.Name, Room: room, Status: m.Status, Voice: m.Voice, Time: time.Now()}
+}
+
+// NewTyping returns the frame telling room whether sender is writing a
+// message.
+func NewTyping(room, sender string, active bool) *Envelope {
+	return &Envelope{Type: TypeTyping, Sender: sender, Room: room, Active: active, Time: time.Now()}
+}
+
+// NewNotice returns a server generated message for room.
+func NewNotice(room, body string) *Envelope {
+	return &Envelope{Type: TypeNotice, Sender: SenderServer, Room: room, Body: body, Time: time.Now()}
+}
+
+// NewEphemeral returns a notice meant only for the client it is sent to.
+func NewEphemeral(room, body string) *Envelope {
+	return &Envelope{Type: TypeNotice, Sender: SenderServer, Room: room, Body: body, Ephemeral: true, Time: time.Now()}
+}
+
+// NewError returns an error reply.
+func NewError(body string) *Envelope {
+	return &Envelope{Type: TypeError, Sender: SenderServer, Body: body, Time: time.Now()}
+}
+
+// NewGoodbye returns the frame announcing that the server closes the
+// connection.
+func NewGoodbye(reason Reason, body string) *Envelope {
+	return &Envelope{Type: TypeGoodbye, Sender: SenderServer, Reason: reason, Body: body, Time: time.Now()}
+}
+
+// Encode returns env as a complete frame, length prefix included.
+func Encode(env *Envelope) ([]byte, error) {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding envelope: %w", err)
+	}
+	if len(payload) > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	return frame, nil
+}
+
+// WriteFrame writes env to w as a single Write call, so concurrent writers on
+// a net.Conn never interleave partial frames.
+func WriteFrame(w io.Writer, env *Envelope) error {
+	frame, err := Encode(env)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// Decoder reads frames from a stream.
+type Decoder struct {
+	rd  *bufio.Reader
+	max int
+}
+
+// NewDecoder returns a decoder accepting frames up to MaxFrameSize.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderSize(r, MaxFrameSize)
+}
+
+// NewDecoderSize returns a decoder accepting frames up to max bytes, capped
+// at MaxFrameSize.
+func NewDecoderSize(r io.Reader, max int) *Decoder {
+	if max <= 0 || max > MaxFrameSize {
+		max = MaxFrameSize
+	}
+	return &Decoder{rd: bufio.NewReader(r), max: max}
+}
+
+// Decode reads the next frame. It returns io.EOF when the stream ends cleanly
+// between frames and a *FrameTooLargeError after skipping an oversized one.
+func (d *Decoder) Decode() (*Envelope, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(d.rd, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > uint32(d.max) {
+		// Read past the payload without buffering it, the stream stays usable
+		if _, err := io.CopyN(io.Discard, d.rd, int64(size)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return nil, &FrameTooLargeError{Size: int(size), Max: d.max}
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(d.rd, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	env := &Envelope{}
+	if err := json.Unmarshal(payload, env); err != nil {
+		return nil, fmt.Errorf("error decoding envelope: %w", err)
+	}
+
+	return env, nil
+}
+
+// CertFingerprint returns the SHA-256 fingerprint of a DER encoded
+// certificate, as printed by the server and pinned by clients.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
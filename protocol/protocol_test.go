@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// frame returns payload with a length prefix of size.
+func frame(size uint32, payload string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, size)
+	return append(b, payload...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []*Envelope{
+		NewHello("alice", &Auth{Password: "secret", Register: true}),
+		{Type: TypeChat, ID: 7, Sender: "bob", Room: "general", Body: "multi\nline \"quoted\" ünïcode 🎉", Time: when},
+		{Type: TypeDirect, Sender: "bob", To: "alice", Body: "psst", Time: when},
+		{Type: TypeHistory, Sender: SenderServer, Room: "general", More: true, History: []*Envelope{
+			{Type: TypeChat, ID: 1, Sender: "bob", Body: "one", Time: when},
+			{Type: TypeChat, ID: 2, Sender: "carol", Body: "two", Time: when, ReplyTo: 1, Quote: &Quote{Sender: "bob", Body: "one"}},
+		}},
+		NewGoodbye(ReasonShutdown, "bye"),
+	}
+
+	var stream bytes.Buffer
+	for _, env := range tests {
+		if err := WriteFrame(&stream, env); err != nil {
+			t.Fatalf("WriteFrame(%s): %v", env.Type, err)
+		}
+	}
+
+	dec := NewDecoder(&stream)
+	for _, want := range tests {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", want.Type, err)
+		}
+		// Times lose their monotonic clock reading on the wire
+		want := *want
+		want.Time = want.Time.Round(0)
+		if !got.Time.Equal(want.Time) {
+			t.Errorf("%s time = %v, want %v", want.Type, got.Time, want.Time)
+		}
+		got.Time = want.Time
+		if !reflect.DeepEqual(got, &want) {
+			t.Errorf("Decode = %+v, want %+v", got, &want)
+		}
+	}
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("Decode at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		// want is the error Decode must match, nil for any
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"short header", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"short payload", frame(10, `{"type"`), io.ErrUnexpectedEOF},
+		{"bad json", frame(5, `{"typ`), nil},
+	}
+
+	for _, tt := range tests {
+		_, err := NewDecoder(bytes.NewReader(tt.stream)).Decode()
+		if err == nil {
+			t.Errorf("%s: Decode succeeded", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: Decode error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// Oversized frames are skipped and reported, the frames after them still
+// decode.
+func TestFrameTooLarge(t *testing.T) {
+	chat := func(body string) []byte {
+		payload := `{"type":"chat","body":"` + body + `"}`
+		return frame(uint32(len(payload)), payload)
+	}
+	oversized := func(size int) []byte {
+		return frame(uint32(size), string(bytes.Repeat([]byte("x"), size)))
+	}
+
+	tests := []struct {
+		name   string
+		max    int
+		stream [][]byte
+		// want is the body of each frame decoded, "" for one skipped
+		want []string
+	}{
+		{"within limit", 64, [][]byte{chat("first"), chat("second")}, []string{"first", "second"}},
+		{"over the decoder limit", 64, [][]byte{chat("first"), oversized(65), chat("after")}, []string{"first", "", "after"}},
+		{"consecutive", 64, [][]byte{oversized(100), oversized(1000), chat("after")}, []string{"", "", "after"}},
+		{"over MaxFrameSize", 0, [][]byte{oversized(MaxFrameSize + 1), chat("after")}, []string{"", "after"}},
+		{"limit capped at MaxFrameSize", 2 * MaxFrameSize, [][]byte{oversized(MaxFrameSize + 1), chat("after")}, []string{"", "after"}},
+	}
+
+	for _, tt := range tests {
+		dec := NewDecoderSize(bytes.NewReader(bytes.Join(tt.stream, nil)), tt.max)
+
+		for i, want := range tt.want {
+			env, err := dec.Decode()
+			if want == "" {
+				var tooLarge *FrameTooLargeError
+				if !errors.As(err, &tooLarge) || !errors.Is(err, ErrFrameTooLarge) {
+					t.Errorf("%s: frame %d error = %v, want FrameTooLargeError", tt.name, i, err)
+				}
+				continue
+			}
+			if err != nil || env.Body != want {
+				t.Errorf("%s: frame %d = %v, %v, want %q", tt.name, i, env, err, want)
+			}
+		}
+
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Errorf("%s: Decode at the end = %v, want io.EOF", tt.name, err)
+		}
+	}
+
+	if _, err := NewDecoderSize(bytes.NewReader(frame(100, "short")), 16).Decode(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated oversized frame error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	env := NewChat("general", "bob", string(bytes.Repeat([]byte("x"), MaxFrameSize)))
+	if _, err := Encode(env); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Encode error = %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoAccount = errors.New("no such account")
+
+// Account is a registered user. Tokens only hold hashes, the tokens
+// themselves are handed to the client once and never stored.
+type Account struct {
+	Name         string       `json:"name"`
+	PasswordHash []byte       `json:"passwordHash"`
+	Tokens       []TokenEntry `json:"tokens,omitempty"`
+	Created      time.Time    `json:"created"`
+}
+
+type TokenEntry struct {
+	Hash    string    `json:"hash"`
+	Expires time.Time `json:"expires"`
+}
+
+// AccountStore persists accounts. Names are matched case-insensitively.
+// Implementations must be safe for concurrent use.
+type AccountStore interface {
+	// Get returns ErrNoAccount if name is not registered
+	Get(name string) (*Account, error)
+	// Put creates or replaces the account
+	Put(acc *Account) error
+}
+
+func accountKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// MemoryAccounts keeps accounts in memory only. It is the default when no
+// AccountStore is configured.
+type MemoryAccounts struct {
+	mu       sync.RWMutex
+	accounts map[string]*Account
+}
+
+func NewMemoryAccounts() *MemoryAccounts {
+	return &MemoryAccounts{accounts: map[string]*Account{}}
+}
+
+func (m *MemoryAccounts) Get(name string) (*Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	acc, ok := m.accounts[accountKey(name)]
+	if !ok {
+		return nil, ErrNoAccount
+	}
+
+	// Hand out a copy so callers can modify it before Put
+	cp := *acc
+	cp.Tokens = append([]TokenEntry(nil), acc.Tokens...)
+
+	return &cp, nil
+}
+
+func (m *MemoryAccounts) Put(acc *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accounts[accountKey(acc.Name)] = acc
+
+	return nil
+}
+
+// FileAccounts stores accounts as a JSON file that is rewritten on every
+// change.
+type FileAccounts struct {
+	*MemoryAccounts
+
+	mu   sync.Mutex
+	path string
+}
+
+// OpenFileAccounts loads the accounts file at path, which may not exist yet.
+func OpenFileAccounts(path string) (*FileAccounts, error) {
+	mem := NewMemoryAccounts()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading accounts file: %w", err)
+	}
+
+	if len(data) > 0 {
+		var list []*Account
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("error decoding accounts file: %w", err)
+		}
+		for _, acc := range list {
+			mem.accounts[accountKey(acc.Name)] = acc
+		}
+	}
+
+	return &FileAccounts{MemoryAccounts: mem, path: path}, nil
+}
+
+func (f *FileAccounts) Put(acc *Account) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.MemoryAccounts.Put(acc)
+
+	f.MemoryAccounts.mu.RLock()
+	list := make([]*Account, 0, len(f.MemoryAccounts.accounts))
+	for _, a := range f.MemoryAccounts.accounts {
+		list = append(list, a)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	f.MemoryAccounts.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("error encoding accounts: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".accounts-*")
+	if err != nil {
+		return fmt.Errorf("error writing accounts file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing accounts file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing accounts file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing accounts file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
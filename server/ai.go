@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// Persona is a bot users can talk to, through its command or by mentioning
+// its name.
+type Persona struct {
+	// Name is the sender of its answers and what users @mention. It can't be
+	// registered as an account; a persona whose name is reserved, already an
+	// account or not a valid account name is skipped.
+	Name string
+	// Command runs the persona, e.g. "chat" for #chat. Empty makes it
+	// reachable by @mention only.
+	Command string
+	// SystemPrompt and Model default to Config.AISystemPrompt and
+	// Config.AIModel
+	SystemPrompt string
+	Model        string
+	// Temperature is left to the model when nil
+	Temperature *float64
+}
+
+// personaNameError returns why a persona can't be called name: it would
+// pose as the server or someone with an account, or can't be @mentioned.
+func personaNameError(cfg Config, name string) error {
+	if !accountName.MatchString(name) {
+		return errors.New("names must be 2-32 letters, digits, '.', '-' or '_'")
+	}
+
+	if strings.EqualFold(name, protocol.SenderServer) || slices.ContainsFunc(cfg.ReservedNames, func(r string) bool { return strings.EqualFold(r, name) }) {
+		return fmt.Errorf("%q is reserved", name)
+	}
+
+	if _, err := cfg.Accounts.Get(name); err == nil {
+		return fmt.Errorf("%q is a registered account", name)
+	} else if !errors.Is(err, ErrNoAccount) {
+		return err
+	}
+
+	return nil
+}
+
+// aiExchange is one prompt to a bot and its answer.
+type aiExchange struct {
+	prompt string
+	answer string
+}
+
+// aiMemory is what a bot remembers of the conversation in one room.
+type aiMemory struct {
+	exchanges []aiExchange
+}
+
+// estimateTokens approximates the token count of text, about four
+// characters per token for English plus a few for the message framing.
+func estimateTokens(text string) int {
+	return (len(text)+3)/4 + 4
+}
+
+func (m *aiMemory) tokens() int {
+	n := 0
+	for _, e := range m.exchanges {
+		n += estimateTokens(e.prompt) + estimateTokens(e.answer)
+	}
+	return n
+}
+
+// trim drops the oldest exchanges until at most maxTurns remain and they fit
+// in maxTokens together with the reserved tokens.
+func (m *aiMemory) trim(maxTurns, maxTokens, reserved int) {
+	if maxTurns = max(maxTurns, 0); len(m.exchanges) > maxTurns {
+		m.exchanges = m.exchanges[len(m.exchanges)-maxTurns:]
+	}
+
+	for len(m.exchanges) > 0 && m.tokens()+reserved > maxTokens {
+		m.exchanges = m.exchanges[1:]
+	}
+}
+
+// memory returns what p remembers of room, s.aiMu must be held.
+func (s *Server) memory(room string, p *Persona) *aiMemory {
+	personas := s.aiRooms[room]
+	if personas == nil {
+		personas = map[string]*aiMemory{}
+		s.aiRooms[room] = personas
+	}
+
+	key := strings.ToLower(p.Name)
+	mem := personas[key]
+	if mem == nil {
+		mem = &aiMemory{}
+		personas[key] = mem
+	}
+
+	return mem
+}
+
+// aiContext returns the messages for a completion of prompt by p in room:
+// the system prompt, whatever of the room's history fits the budget, and the
+// prompt.
+func (s *Server) aiContext(room string, p *Persona, prompt string) []AIMessage {
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	mem := s.memory(room, p)
+	// Make room for the new prompt and its answer up front
+	mem.trim(s.cfg.AIMaxTurns-1, s.cfg.AIMaxTokens, estimateTokens(p.SystemPrompt)+estimateTokens(prompt)*2)
+
+	messages := []AIMessage{{Role: RoleSystem, Content: p.SystemPrompt}}
+	for _, e := range mem.exchanges {
+		messages = append(messages, AIMessage{Role: RoleUser, Content: e.prompt}, AIMessage{Role: RoleAssistant, Content: e.answer})
+	}
+
+	return append(messages, AIMessage{Role: RoleUser, Content: prompt})
+}
+
+// remember adds an answered prompt to what p remembers of room.
+func (s *Server) remember(room string, p *Persona, prompt, answer string) {
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	mem := s.memory(room, p)
+	mem.exchanges = append(mem.exchanges, aiExchange{prompt: prompt, answer: answer})
+	mem.trim(s.cfg.AIMaxTurns, s.cfg.AIMaxTokens, estimateTokens(p.SystemPrompt))
+}
+
+// forgetAI clears what every persona remembers of room.
+func (s *Server) forgetAI(room string) {
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	delete(s.aiRooms, room)
+}
+
+// mentionedPersona returns the first persona @mentioned in body, if any.
+func (s *Server) mentionedPersona(body string) *Persona {
+	for _, word := range strings.Fields(body) {
+		name, ok := strings.CutPrefix(word, "@")
+		if !ok {
+			continue
+		}
+		name = strings.TrimRight(name, ".,;:!?")
+
+		for i := range s.cfg.Personas {
+			if strings.EqualFold(s.cfg.Personas[i].Name, name) {
+				return &s.cfg.Personas[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// streamInterval is the least time between two updates of a bot message
+// being written, so clients aren't flooded with a frame per token.
+const streamInterval = 200 * time.Millisecond
+
+// ask has p answer prompt from sess in room. The answer is broadcast to the
+// room as it is written, errors before the request is made are returned.
+func (s *Server) ask(sess *session, room string, p *Persona, prompt string) error {
+	if s.provider == nil {
+		fmt.Println("<No API Key Found>")
+		return errors.New("the AI bot is not set up, the server has no OpenAI API key")
+	}
+
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return errors.New("the prompt is empty")
+	}
+	if err := s.limiter.allow(sess.name); err != nil {
+		return err
+	}
+	prompt = sess.name + ": " + prompt
+
+	req := s.queueAI(room)
+	s.aiRunning.Add(1)
+
+	// The completion takes seconds, don't hold up the sender meanwhile
+	go func() {
+		defer s.aiRunning.Done()
+		defer s.finishAI(room, req)
+
+		if err := req.wait(); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.ctx, s.cfg.AITimeout)
+		defer cancel()
+
+		s.relay(nil, protocol.NewThinking(room, p.Name, true))
+		answer := &streamedAnswer{server: s, room: room, persona: p}
+
+		raw_rsp, err := s.chat(ctx, sess.name, room, p, prompt, answer.add)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if answer.msg == nil {
+			s.relay(nil, protocol.NewThinking(room, p.Name, false))
+		}
+
+		switch {
+		case answer.finish(raw_rsp):
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			sess.send(protocol.NewEphemeral(room, fmt.Sprintf("%s took too long to answer, try again later", p.Name)))
+		case ctx.Err() == nil:
+			sess.send(protocol.NewEphemeral(room, fmt.Sprintf("%s didn't answer, try again later", p.Name)))
+		}
+	}()
+
+	return nil
+}
+
+// aiQueue lets the bots answer the prompts of one room one at a time, so
+// every answer knows the exchanges before it.
+type aiQueue struct {
+	// turn is held by the request being answered
+	turn chan struct{}
+	// pending holds the requests being answered or waiting for their turn
+	pending map[*aiRequest]struct{}
+}
+
+// aiRequest is a prompt in the queue of a room.
+type aiRequest struct {
+	// ctx ends on #ai cancel or Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  *aiQueue
+	// holding is set once the request got its turn
+	holding bool
+}
+
+// queueAI adds a request to the queue of room, finishAI must be called once
+// it is done.
+func (s *Server) queueAI(room string) *aiRequest {
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	queue := s.aiQueues[room]
+	if queue == nil {
+		queue = &aiQueue{turn: make(chan struct{}, 1), pending: map[*aiRequest]struct{}{}}
+		s.aiQueues[room] = queue
+	}
+
+	req := &aiRequest{queue: queue}
+	req.ctx, req.cancel = context.WithCancel(s.aiCtx)
+	queue.pending[req] = struct{}{}
+
+	return req
+}
+
+// wait blocks until it is the request's turn, or returns why it never will be.
+func (r *aiRequest) wait() error {
+	select {
+	case r.queue.turn <- struct{}{}:
+		r.holding = true
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
+
+// finishAI hands the turn of req on to the next request of room.
+func (s *Server) finishAI(room string, req *aiRequest) {
+	req.cancel()
+	if req.holding {
+		<-req.queue.turn
+	}
+
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	delete(req.queue.pending, req)
+	if len(req.queue.pending) == 0 && s.aiQueues[room] == req.queue {
+		delete(s.aiQueues, room)
+	}
+}
+
+// cancelAI stops every answer being written or waiting in room. What was
+// written so far is kept. It returns how many requests were cancelled.
+func (s *Server) cancelAI(room string) int {
+	s.aiMu.Lock()
+	defer s.aiMu.Unlock()
+
+	queue := s.aiQueues[room]
+	if queue == nil {
+		return 0
+	}
+	for req := range queue.pending {
+		req.cancel()
+	}
+
+	return len(queue.pending)
+}
+
+// streamedAnswer is a bot message that is sent as soon as its first words
+// arrive and then edited until it is complete.
+type streamedAnswer struct {
+	server  *Server
+	room    string
+	persona *Persona
+
+	// msg is the message as broadcast, nil until the first words arrived.
+	// The store holds it, so it is never modified.
+	msg  *protocol.Envelope
+	text strings.Builder
+	sent time.Time
+}
+
+func (a *streamedAnswer) add(delta string) {
+	if a.text.Len()+len(delta) > maxMessageLimit {
+		return
+	}
+	a.text.WriteString(delta)
+
+	if a.msg == nil {
+		a.server.relay(nil, protocol.NewThinking(a.room, a.persona.Name, false))
+		a.msg = protocol.NewChat(a.room, a.persona.Name, a.text.String())
+		a.msg.Bot = true
+		a.msg.Streaming = true
+		a.server.broadcastMsg(nil, a.msg)
+		a.sent = time.Now()
+		return
+	}
+
+	if time.Since(a.sent) >= streamInterval {
+		edit := protocol.NewEdit(a.room, a.msg.ID, a.text.String())
+		edit.Sender = a.persona.Name
+		edit.Streaming = true
+		a.server.relay(nil, edit)
+		a.sent = time.Now()
+	}
+}
+
+// finish sends the complete answer, or what arrived of it if the request
+// failed midway, and stores it. It reports false if there was nothing to
+// send.
+func (a *streamedAnswer) finish(answer string) bool {
+	if answer == "" {
+		answer = a.text.String()
+	}
+	// Anything longer wouldn't fit in a frame
+	if len(answer) > maxMessageLimit {
+		answer = strings.ToValidUTF8(answer[:maxMessageLimit], "")
+	}
+	if answer == "" {
+		return false
+	}
+
+	// Providers that can't stream deliver everything at once
+	if a.msg == nil {
+		msg := protocol.NewChat(a.room, a.persona.Name, answer)
+		msg.Bot = true
+		a.server.broadcastMsg(nil, msg)
+		return true
+	}
+
+	final := *a.msg
+	final.Body = answer
+	final.Streaming = false
+	if err := a.server.store.Update(&final); err != nil {
+		fmt.Println(err)
+	}
+
+	edit := protocol.NewEdit(a.room, a.msg.ID, answer)
+	edit.Sender = a.persona.Name
+	a.server.relay(nil, edit)
+	return true
+}
+
+// chat asks p to answer prompt of user in room, passing the answer to
+// onDelta as it is written, and remembers the exchange. The tokens used are
+// charged to user.
+func (s *Server) chat(ctx context.Context, user, room string, p *Persona, prompt string, onDelta func(string)) (string, error) {
+	req := CompletionRequest{
+		Model:       p.Model,
+		Temperature: p.Temperature,
+		Messages:    s.aiContext(room, p, prompt),
+	}
+
+	var rsp string
+	var err error
+	if sp, ok := s.provider.(StreamingProvider); ok {
+		rsp, err = sp.Stream(ctx, req, onDelta)
+	} else {
+		rsp, err = s.provider.Complete(ctx, req)
+	}
+
+	tokens := estimateTokens(rsp)
+	for _, m := range req.Messages {
+		tokens += estimateTokens(m.Content)
+	}
+	s.limiter.spend(user, tokens)
+
+	if err != nil {
+		return rsp, err
+	}
+
+	s.remember(room, p, prompt, rsp)
+
+	fmt.Printf("%s RESPONSE: %s\n", p.Name, rsp)
+
+	return rsp, nil
+}
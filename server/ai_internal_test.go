@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestAIMemoryTrim(t *testing.T) {
+	// Every exchange is estimated at 10 tokens, 5 for each side
+	var exchanges []aiExchange
+	for i := 1; i <= 5; i++ {
+		exchanges = append(exchanges, aiExchange{prompt: fmt.Sprintf("p%03d", i), answer: fmt.Sprintf("a%03d", i)})
+	}
+
+	tests := []struct {
+		maxTurns, maxTokens, reserved int
+		// want is the prompts kept
+		want []string
+	}{
+		{10, 100, 0, []string{"p001", "p002", "p003", "p004", "p005"}},
+		{5, 50, 0, []string{"p001", "p002", "p003", "p004", "p005"}},
+		{3, 100, 0, []string{"p003", "p004", "p005"}},
+		{0, 100, 0, nil},
+		{-1, 100, 0, nil},
+		{10, 35, 0, []string{"p003", "p004", "p005"}},
+		{10, 35, 10, []string{"p004", "p005"}},
+		{2, 35, 0, []string{"p004", "p005"}},
+		{10, 9, 0, nil},
+		{10, 100, 200, nil},
+	}
+
+	for _, tt := range tests {
+		m := &aiMemory{exchanges: slices.Clone(exchanges)}
+		m.trim(tt.maxTurns, tt.maxTokens, tt.reserved)
+
+		var got []string
+		for _, e := range m.exchanges {
+			got = append(got, e.prompt)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("trim(%d, %d, %d) kept %v, want %v", tt.maxTurns, tt.maxTokens, tt.reserved, got, tt.want)
+		}
+	}
+}
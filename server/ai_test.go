@@ -0,0 +1,208 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// stubProvider answers every prompt by echoing it and keeps the requests.
+type stubProvider struct {
+	mu       sync.Mutex
+	requests []server.CompletionRequest
+}
+
+func (p *stubProvider) Complete(_ context.Context, req server.CompletionRequest) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, req)
+	return "echo " + req.Messages[len(req.Messages)-1].Content, nil
+}
+
+func (p *stubProvider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.requests)
+}
+
+// botAnswer matches a chat message written by a persona.
+func botAnswer(env *protocol.Envelope) bool {
+	return env.Type == protocol.TypeChat && env.Bot
+}
+
+// Personas answer their command and @mentions in front of the whole room.
+func TestPersonaAnswers(t *testing.T) {
+	stub := &stubProvider{}
+	s := startServer(t, server.Config{
+		AIProvider:     stub,
+		AISystemPrompt: "be brief",
+		Personas:       []server.Persona{{Name: "Helper", Command: "ask"}, {Name: "Quiet"}},
+	})
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	tests := []struct {
+		prompt, sender, answer string
+	}{
+		{"#ask what's up", "Helper", "echo alice: what's up"},
+		{"hey @quiet, you there?", "Quiet", "echo alice: hey @quiet, you there?"},
+	}
+
+	for _, tt := range tests {
+		alice.Send(protocol.DefaultChannel, tt.prompt)
+
+		for _, c := range []*client.Client{alice, bob} {
+			answer := waitFor(t, c, botAnswer)
+			if answer.Sender != tt.sender || answer.Body != tt.answer || answer.ID == 0 {
+				t.Errorf("%s: %s got %s %q (id %d), want %s %q", tt.prompt, c.DisplayName(), answer.Sender, answer.Body, answer.ID, tt.sender, tt.answer)
+			}
+		}
+	}
+
+	if n := stub.count(); n != len(tests) {
+		t.Fatalf("provider got %d requests, want %d", n, len(tests))
+	}
+	if first := stub.requests[0].Messages[0]; first.Role != server.RoleSystem || first.Content != "be brief" {
+		t.Errorf("first message of the request = %+v, want the system prompt", first)
+	}
+}
+
+// Personas can't take the name of the server, a reserved name or an
+// account, and their own names can't be registered.
+func TestPersonaNames(t *testing.T) {
+	accounts := server.NewMemoryAccounts()
+	accounts.Put(&server.Account{Name: "alice", Created: time.Now()})
+
+	stub := &stubProvider{}
+	s := startServer(t, server.Config{
+		Accounts:      accounts,
+		AIProvider:    stub,
+		ReservedNames: []string{"admin"},
+		Personas: []server.Persona{
+			{Name: "Server", Command: "srv"},
+			{Name: "Admin", Command: "adm"},
+			{Name: "Alice", Command: "fake"},
+			{Name: "two words", Command: "spaced"},
+			{Name: "Helper", Command: "ask"},
+		},
+	})
+
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	helper := client.New(client.Config{DisplayName: "helper", ServerAddress: "127.0.0.1", ChatPort: port, Password: testPassword, Register: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := helper.Connect(ctx); !errors.Is(err, client.ErrRefused) {
+		helper.Close()
+		t.Errorf("registering a persona's name: %v, want refused", err)
+	}
+
+	bob := dial(t, s, "bob", true, false)
+	for _, msg := range []string{"#srv hi", "#adm hi", "#fake hi", "#spaced hi", "@alice hi", "@server hi", "#ask ping"} {
+		bob.Send(protocol.DefaultChannel, msg)
+	}
+
+	// Prompts of a room are answered in order, anything skipped would come
+	// first
+	answer := waitFor(t, bob, botAnswer)
+	if answer.Sender != "Helper" || answer.Body != "echo bob: ping" {
+		t.Errorf("first answer = %s %q, want Helper's to ping", answer.Sender, answer.Body)
+	}
+	if n := stub.count(); n != 1 {
+		t.Errorf("provider got %d requests, want 1", n)
+	}
+}
+
+// streamProvider hands out its deltas one by one, gap apart, after the
+// first one waiting for hold to be closed if it is set.
+type streamProvider struct {
+	stubProvider
+	deltas []string
+	gap    time.Duration
+	hold   chan struct{}
+}
+
+func (p *streamProvider) Stream(ctx context.Context, _ server.CompletionRequest, onDelta func(string)) (string, error) {
+	var answer strings.Builder
+	for i, delta := range p.deltas {
+		if i > 0 {
+			if i == 1 && p.hold != nil {
+				select {
+				case <-ctx.Done():
+					return answer.String(), ctx.Err()
+				case <-p.hold:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return answer.String(), ctx.Err()
+			case <-time.After(p.gap):
+			}
+		}
+		answer.WriteString(delta)
+		onDelta(delta)
+	}
+	return answer.String(), nil
+}
+
+// A streamed answer shows up as a placeholder that is edited until it is
+// complete, and only the complete answer is stored.
+func TestStreamedAnswer(t *testing.T) {
+	stub := &streamProvider{deltas: []string{"one", " two", " three"}, gap: 250 * time.Millisecond}
+	s := startServer(t, server.Config{
+		AIProvider: stub,
+		Personas:   []server.Persona{{Name: "Helper", Command: "ask"}},
+	})
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	alice.Send(protocol.DefaultChannel, "#ask count")
+
+	placeholder := waitFor(t, bob, botAnswer)
+	if !placeholder.Streaming || placeholder.Body != "one" || placeholder.ID == 0 {
+		t.Fatalf("placeholder = %+v, want streaming one", placeholder)
+	}
+
+	var edits []*protocol.Envelope
+	for {
+		edit := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeEdit })
+		if edit.ID != placeholder.ID || edit.Sender != "Helper" {
+			t.Fatalf("edit of %d by %s, want %d by Helper", edit.ID, edit.Sender, placeholder.ID)
+		}
+		edits = append(edits, edit)
+		if !edit.Streaming {
+			break
+		}
+	}
+	if len(edits) < 2 {
+		t.Errorf("got %d edits, want the streamed ones before the final one", len(edits))
+	}
+	if final := edits[len(edits)-1]; final.Body != "one two three" {
+		t.Errorf("final edit = %q, want the whole answer", final.Body)
+	}
+
+	if err := bob.RequestHistory(protocol.DefaultChannel, 0); err != nil {
+		t.Fatal(err)
+	}
+	history := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeHistory })
+	var stored *protocol.Envelope
+	for _, m := range history.History {
+		if m.ID == placeholder.ID {
+			stored = m
+		}
+	}
+	if stored == nil || stored.Body != "one two three" || stored.Streaming || !stored.Bot {
+		t.Errorf("stored answer = %+v, want the whole answer", stored)
+	}
+}
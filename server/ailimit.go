@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateWindow is the period the AI rate limits are counted over.
+const rateWindow = time.Minute
+
+// aiLimiter enforces the prompt rate limits and daily token budgets of the
+// bots. It is safe for concurrent use.
+type aiLimiter struct {
+	userRate, globalRate     int
+	userTokens, globalTokens int
+	// now is the clock, replaced in tests
+	now func() time.Time
+
+	mu sync.Mutex
+	// prompts holds the times of the prompts within the last rateWindow, by
+	// lowercase user name, all of them under ""
+	prompts map[string][]time.Time
+	// day is the date the token counts belong to, they start over with the
+	// next one
+	day    string
+	tokens map[string]int
+	total  int
+}
+
+func newAILimiter(cfg Config) *aiLimiter {
+	return &aiLimiter{
+		userRate:     cfg.AIUserRate,
+		globalRate:   cfg.AIGlobalRate,
+		userTokens:   cfg.AIUserDailyTokens,
+		globalTokens: cfg.AIDailyTokens,
+		now:          time.Now,
+		prompts:      map[string][]time.Time{},
+		tokens:       map[string]int{},
+	}
+}
+
+// allow records a prompt by user, or returns why it has to be turned down.
+func (l *aiLimiter) allow(user string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	key := strings.ToLower(user)
+	l.rollover(now)
+
+	if l.globalTokens > 0 && l.total >= l.globalTokens {
+		return errors.New("the bots have used up today's token budget")
+	}
+	if l.userTokens > 0 && l.tokens[key] >= l.userTokens {
+		return errors.New("you have used up your token budget for today")
+	}
+
+	global := l.recent("", now)
+	if l.globalRate > 0 && len(global) >= l.globalRate {
+		return fmt.Errorf("the bots are busy, try again in %s", retryIn(global, now))
+	}
+	own := l.recent(key, now)
+	if l.userRate > 0 && len(own) >= l.userRate {
+		return fmt.Errorf("you're asking too fast, try again in %s", retryIn(own, now))
+	}
+
+	l.prompts[""] = append(global, now)
+	l.prompts[key] = append(own, now)
+
+	return nil
+}
+
+// spend charges tokens used by a prompt of user against the daily budgets.
+func (l *aiLimiter) spend(user string, tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(l.now())
+	l.tokens[strings.ToLower(user)] += tokens
+	l.total += tokens
+}
+
+// recent drops the prompts of key older than rateWindow and returns the rest.
+func (l *aiLimiter) recent(key string, now time.Time) []time.Time {
+	times := l.prompts[key]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= rateWindow {
+		i++
+	}
+	times = times[i:]
+
+	if len(times) == 0 {
+		delete(l.prompts, key)
+	}
+	return times
+}
+
+// rollover resets the token counts once the day changed.
+func (l *aiLimiter) rollover(now time.Time) {
+	day := now.Format(time.DateOnly)
+	if day == l.day {
+		return
+	}
+
+	l.day = day
+	l.tokens = map[string]int{}
+	l.total = 0
+}
+
+// retryIn is how long until the oldest of times leaves the rate window.
+func retryIn(times []time.Time, now time.Time) time.Duration {
+	return rateWindow - now.Sub(times[0]).Truncate(time.Second)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAILimiter(t *testing.T) {
+	// step is a prompt by user at offset after the start, or the tokens it
+	// spent when tokens is set
+	type step struct {
+		at     time.Duration
+		user   string
+		tokens int
+		denied bool
+	}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		steps []step
+	}{
+		{"no limits", Config{}, []step{
+			{0, "alice", 0, false},
+			{0, "alice", 1_000_000, false},
+			{0, "alice", 0, false},
+		}},
+		{"user rate", Config{AIUserRate: 2}, []step{
+			{0, "alice", 0, false},
+			{time.Second, "alice", 0, false},
+			{2 * time.Second, "ALICE", 0, true},
+			{2 * time.Second, "bob", 0, false},
+			{time.Minute, "alice", 0, false},
+			{time.Minute, "alice", 0, true},
+			{time.Minute + time.Second, "alice", 0, false},
+		}},
+		{"denied prompts don't count", Config{AIUserRate: 1}, []step{
+			{0, "alice", 0, false},
+			{30 * time.Second, "alice", 0, true},
+			{time.Minute, "alice", 0, false},
+		}},
+		{"global rate", Config{AIGlobalRate: 2, AIUserRate: 5}, []step{
+			{0, "alice", 0, false},
+			{0, "bob", 0, false},
+			{0, "carol", 0, true},
+			{time.Minute, "carol", 0, false},
+		}},
+		{"user tokens", Config{AIUserDailyTokens: 100}, []step{
+			{0, "alice", 0, false},
+			{0, "alice", 60, false},
+			{0, "alice", 0, false},
+			{0, "alice", 50, false},
+			{0, "alice", 0, true},
+			{0, "bob", 0, false},
+			{24 * time.Hour, "alice", 0, false},
+		}},
+		{"global tokens", Config{AIDailyTokens: 100}, []step{
+			{0, "alice", 100, false},
+			{0, "bob", 0, true},
+			{24 * time.Hour, "bob", 0, false},
+		}},
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	for _, tt := range tests {
+		l := newAILimiter(tt.cfg)
+
+		for i, st := range tt.steps {
+			l.now = func() time.Time { return start.Add(st.at) }
+
+			if st.tokens > 0 {
+				l.spend(st.user, st.tokens)
+				continue
+			}
+			if err := l.allow(st.user); (err != nil) != st.denied {
+				t.Errorf("%s: step %d, %s at %s: error = %v, want denied %v", tt.name, i, st.user, st.at, err, st.denied)
+			}
+		}
+	}
+}
+
+func TestRetryIn(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		oldest time.Duration
+		want   time.Duration
+	}{
+		{0, time.Minute},
+		{20 * time.Second, 40 * time.Second},
+		{59*time.Second + 500*time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := retryIn([]time.Time{now.Add(-tt.oldest)}, now); got != tt.want {
+			t.Errorf("retryIn(%s ago) = %s, want %s", tt.oldest, got, tt.want)
+		}
+	}
+}
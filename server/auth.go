@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// tokenTTL is how long a login token stays valid
+	tokenTTL = 30 * 24 * time.Hour
+	// maxTokens caps the tokens per account, the oldest is dropped first
+	maxTokens = 10
+
+	minPasswordLength = 8
+)
+
+var accountName = regexp.MustCompile(`^[A-Za-z0-9_.-]{2,32}$`)
+
+// errBadCredentials is deliberately vague so it does not reveal which
+// accounts exist
+var errBadCredentials = errors.New("invalid name or password")
+
+// reserved reports whether name belongs to a system identity that users may
+// not register.
+func (s *Server) reserved(name string) bool {
+	for _, r := range append([]string{protocol.SenderServer, protocol.SenderAI}, s.cfg.ReservedNames...) {
+		if strings.EqualFold(r, name) {
+			return true
+		}
+	}
+	for _, p := range s.cfg.Personas {
+		if strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate checks the credentials of a hello and returns the account
+// and a login token for it. Passwords are hashed and compared without
+// s.accountsMu held, bcrypt is slow by design and would stall every other
+// login.
+func (s *Server) authenticate(name string, auth *protocol.Auth) (*Account, string, error) {
+	if auth == nil {
+		return nil, "", errors.New("login required")
+	}
+
+	var acc *Account
+	var err error
+
+	switch {
+	case auth.Register:
+		acc, err = s.register(name, auth.Password)
+	case auth.Password != "":
+		err = s.login(name, auth.Password)
+	case auth.Token != "":
+		acc, err = s.accounts.Get(name)
+		if errors.Is(err, ErrNoAccount) || (err == nil && !acc.hasToken(auth.Token)) {
+			err = errors.New("login token invalid or expired")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return acc, auth.Token, nil
+	default:
+		return nil, "", errors.New("password or token required")
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.accountsMu.Lock()
+	defer s.accountsMu.Unlock()
+
+	// Load the account again, another login may have changed it or taken
+	// the name in the meantime
+	if auth.Register {
+		if err := s.available(name); err != nil {
+			return nil, "", err
+		}
+	} else if acc, err = s.accounts.Get(name); errors.Is(err, ErrNoAccount) {
+		return nil, "", errBadCredentials
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	token, err := acc.issueToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.accounts.Put(acc); err != nil {
+		fmt.Println(err)
+		return nil, "", errors.New("unable to save account")
+	}
+
+	if auth.Register {
+		fmt.Printf("<account %s registered>\n", name)
+	}
+
+	return acc, token, nil
+}
+
+// register returns a new account for name, not yet saved.
+func (s *Server) register(name, password string) (*Account, error) {
+	if !accountName.MatchString(name) {
+		return nil, errors.New("names must be 2-32 letters, digits, '.', '-' or '_'")
+	}
+
+	if s.reserved(name) {
+		return nil, fmt.Errorf("%q is reserved", name)
+	}
+
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	// Checked before hashing to fail fast, and again before saving
+	if err := s.available(name); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+
+	return &Account{Name: name, PasswordHash: hash, Created: time.Now()}, nil
+}
+
+// available returns an error if an account named name exists.
+func (s *Server) available(name string) error {
+	if _, err := s.accounts.Get(name); err == nil {
+		return fmt.Errorf("%q is already taken", name)
+	} else if !errors.Is(err, ErrNoAccount) {
+		return err
+	}
+	return nil
+}
+
+// login checks password against the stored hash of name.
+func (s *Server) login(name, password string) error {
+	acc, err := s.accounts.Get(name)
+	if errors.Is(err, ErrNoAccount) {
+		return errBadCredentials
+	} else if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword(acc.PasswordHash, []byte(password)) != nil {
+		return errBadCredentials
+	}
+
+	return nil
+}
+
+// accountForToken returns the named account if token is one of its valid
+// login tokens.
+func (s *Server) accountForToken(name, token string) (*Account, bool) {
+	acc, err := s.accounts.Get(name)
+	if err != nil || !acc.hasToken(token) {
+		return nil, false
+	}
+	return acc, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken creates a new login token, dropping expired and surplus ones.
+func (acc *Account) issueToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	acc.Tokens = slices.DeleteFunc(acc.Tokens, func(t TokenEntry) bool {
+		return time.Now().After(t.Expires)
+	})
+	acc.Tokens = append(acc.Tokens, TokenEntry{Hash: hashToken(token), Expires: time.Now().Add(tokenTTL)})
+	if len(acc.Tokens) > maxTokens {
+		acc.Tokens = acc.Tokens[len(acc.Tokens)-maxTokens:]
+	}
+
+	return token, nil
+}
+
+func (acc *Account) hasToken(token string) bool {
+	hash := hashToken(token)
+	for _, t := range acc.Tokens {
+		if t.Hash == hash && time.Now().Before(t.Expires) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// Concurrent logins hash passwords in parallel, but a name can still only
+// be registered once and every login keeps its token.
+func TestConcurrentLogins(t *testing.T) {
+	accounts := server.NewMemoryAccounts()
+	s := startServer(t, server.Config{Accounts: accounts})
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+
+	connect := func(register bool) *client.Client {
+		c := client.New(client.Config{
+			DisplayName:   "alice",
+			ServerAddress: "127.0.0.1",
+			ChatPort:      port,
+			Password:      testPassword,
+			Register:      register,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := c.Connect(ctx); err != nil {
+			return nil
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	const n = 4
+	var wg sync.WaitGroup
+	var registered atomic.Int32
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if connect(true) != nil {
+				registered.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := registered.Load(); got != 1 {
+		t.Fatalf("%d of %d registrations of one name succeeded, want 1", got, n)
+	}
+
+	clients := make([]*client.Client, n)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i] = connect(false)
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range clients {
+		if c == nil {
+			t.Fatalf("login %d failed", i)
+		}
+	}
+
+	acc, err := accounts.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acc.Tokens) != n+1 {
+		t.Errorf("account has %d tokens, want %d", len(acc.Tokens), n+1)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// builtinCommands are registered by New, embedders can replace them with
+// Register.
+func (s *Server) builtinCommands() []Command {
+	return []Command{
+		{
+			Name: "help",
+			Args: []Arg{{Name: "command", Optional: true}},
+			Help: "List the commands, or show how to use one",
+			Run:  s.helpCommand,
+		},
+		{
+			Name: "room",
+			Help: "Show the users in the current channel",
+			Run: func(ctx *CommandContext) error {
+				var list []string
+				for _, member := range s.members(ctx.Room) {
+					name := member.Name
+					if member.Status == protocol.StatusAway {
+						name += " (away)"
+					}
+					if member.Voice {
+						name += " (voice)"
+					}
+					list = append(list, name)
+				}
+				users := strings.Join(list, ", ")
+				ctx.Respond(fmt.Sprintf("Connected Users %v", "["+users+"]"))
+				return nil
+			},
+		},
+		{
+			Name: "channels",
+			Help: "List every open channel and its member count",
+			Run: func(ctx *CommandContext) error {
+				ctx.Respond(s.channelSummary())
+				return nil
+			},
+		},
+		{
+			Name: "history",
+			Args: []Arg{{Name: "count", Optional: true}},
+			Help: fmt.Sprintf("Replay the last messages of the current channel (default %d, max %d)", s.cfg.HistorySize, maxHistoryRequest),
+			Run: func(ctx *CommandContext) error {
+				limit := s.cfg.HistorySize
+				if arg := ctx.Arg("count"); arg != "" {
+					n, err := strconv.Atoi(arg)
+					if err != nil || n <= 0 {
+						return fmt.Errorf("usage: %s", ctx.Usage())
+					}
+					limit = min(n, maxHistoryRequest)
+				}
+				// Skip the #history message itself, it was just stored
+				s.sendHistory(ctx.sess, ctx.Room, ctx.Message.ID, limit, false)
+				return nil
+			},
+		},
+		{
+			Name: "join",
+			Args: []Arg{{Name: "channel"}},
+			Help: "Join (or create) a channel and switch to it",
+			Run: func(ctx *CommandContext) error {
+				name, err := normalizeChannel(ctx.Arg("channel"))
+				if err != nil {
+					return err
+				}
+				s.join(ctx.sess, name)
+				return nil
+			},
+		},
+		{
+			Name: "leave",
+			Args: []Arg{{Name: "channel", Optional: true}},
+			Help: "Leave a channel, defaults to the current one",
+			Run: func(ctx *CommandContext) error {
+				arg := ctx.Arg("channel")
+				if arg == "" {
+					arg = ctx.Room
+				}
+				name, err := normalizeChannel(arg)
+				if err != nil {
+					return err
+				}
+				if name == protocol.DefaultChannel {
+					return fmt.Errorf("#%s can't be left", protocol.DefaultChannel)
+				}
+				s.leave(ctx.sess, name)
+				return nil
+			},
+		},
+		{
+			Name:   "dm",
+			Args:   []Arg{{Name: "user"}, {Name: "message", Rest: true}},
+			Help:   "Send a private message, shown in its own conversation",
+			Silent: true,
+			Run: func(ctx *CommandContext) error {
+				// The message may be quoted, as the usage suggests
+				s.sendDirect(ctx.sess, ctx.Arg("user"), unquote(ctx.Arg("message")))
+				return nil
+			},
+		},
+		{
+			Name: "ai",
+			Args: []Arg{{Name: "action"}},
+			Help: "Manage the bots, reset clears what they remember of this channel and cancel stops their answers",
+			Run: func(ctx *CommandContext) error {
+				switch strings.ToLower(ctx.Arg("action")) {
+				case "reset":
+					s.forgetAI(ctx.Room)
+					ctx.Broadcast(fmt.Sprintf("<%s cleared the AI's memory of #%s>", ctx.Caller, ctx.Room))
+				case "cancel":
+					if s.cancelAI(ctx.Room) == 0 {
+						return fmt.Errorf("the bots aren't answering anything in #%s", ctx.Room)
+					}
+					ctx.Broadcast(fmt.Sprintf("<%s cancelled the AI's answers in #%s>", ctx.Caller, ctx.Room))
+				default:
+					return fmt.Errorf("usage: %s", ctx.Usage())
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (s *Server) helpCommand(ctx *CommandContext) error {
+	if name := ctx.Arg("command"); name != "" {
+		cmd := s.lookupCommand("#" + strings.TrimPrefix(name, "#"))
+		if cmd == nil || !s.allowed(ctx.Caller, cmd.Permission) {
+			return fmt.Errorf("no command named #%s", strings.TrimPrefix(name, "#"))
+		}
+		ctx.Respond(fmt.Sprintf("%s - %s", cmd.usage(), cmd.Help))
+		return nil
+	}
+
+	lines := []string{"Commands:"}
+	for _, cmd := range s.visibleCommands(ctx.Caller) {
+		lines = append(lines, fmt.Sprintf("%s - %s", cmd.usage(), cmd.Help))
+	}
+	ctx.Respond(strings.Join(lines, "\n"))
+	return nil
+}
+
+// registerPersonas adds the command of every persona that has one. Names
+// taken by other commands are skipped.
+func (s *Server) registerPersonas() {
+	for i := range s.cfg.Personas {
+		p := &s.cfg.Personas[i]
+		if p.Command == "" {
+			continue
+		}
+
+		if s.lookupCommand("#"+p.Command) != nil {
+			fmt.Printf("persona %s: #%s is already a command\n", p.Name, p.Command)
+			continue
+		}
+
+		err := s.Register(Command{
+			Name:   p.Command,
+			Args:   []Arg{{Name: "prompt", Rest: true}},
+			Help:   fmt.Sprintf("Send a message to the %s bot", p.Name),
+			Public: true,
+			Run: func(ctx *CommandContext) error {
+				return s.ask(ctx.sess, ctx.Room, p, ctx.Arg("prompt"))
+			},
+		})
+		if err != nil {
+			fmt.Printf("persona %s: %v\n", p.Name, err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+var channelName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// channel is a named text room. Members are guarded by Server.mu.
+type channel struct {
+	name    string
+	members map[*session]struct{}
+}
+
+// normalizeChannel lower cases name, strips a leading "#" and maps the empty
+// name to the default channel.
+func normalizeChannel(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	if name == "" {
+		return protocol.DefaultChannel, nil
+	}
+
+	if !channelName.MatchString(name) {
+		return "", fmt.Errorf("invalid channel name %q, use up to 32 letters, digits, '-' or '_'", name)
+	}
+
+	return name, nil
+}
+
+// voiceRoom returns the LiveKit room backing a text channel. The default
+// channel keeps the configured room name so existing deployments are unchanged.
+func (s *Server) voiceRoom(name string) string {
+	if name == protocol.DefaultChannel {
+		return s.cfg.RoomName
+	}
+	return s.cfg.RoomName + "_" + name
+}
+
+// joinChannel adds sess to the named channel, creating it if needed. It
+// reports false if sess already was a member.
+func (s *Server) joinChannel(sess *session, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		ch = &channel{name: name, members: map[*session]struct{}{}}
+		s.channels[name] = ch
+		fmt.Printf("<channel #%s created by %s>\n", name, sess.name)
+	}
+
+	if _, ok := ch.members[sess]; ok {
+		return false
+	}
+
+	ch.members[sess] = struct{}{}
+	sess.channels[name] = struct{}{}
+
+	return true
+}
+
+// leaveChannel removes sess from the named channel. Empty channels other than
+// the default one are deleted. It reports false if sess was not a member.
+func (s *Server) leaveChannel(sess *session, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		return false
+	}
+
+	if _, ok := ch.members[sess]; !ok {
+		return false
+	}
+
+	delete(ch.members, sess)
+	delete(sess.channels, name)
+	if sess.voice == name {
+		sess.voice = ""
+	}
+
+	if len(ch.members) == 0 && name != protocol.DefaultChannel {
+		delete(s.channels, name)
+		s.forgetAI(name)
+		fmt.Printf("<channel #%s removed>\n", name)
+	}
+
+	return true
+}
+
+// channelMembers returns the sessions currently in the named channel.
+func (s *Server) channelMembers(name string) []*session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		return nil
+	}
+
+	members := make([]*session, 0, len(ch.members))
+	for member := range ch.members {
+		members = append(members, member)
+	}
+
+	return members
+}
+
+// isMember reports whether sess is in the named channel.
+func (s *Server) isMember(sess *session, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := sess.channels[name]
+	return ok
+}
+
+// accountInChannel reports whether any session of the account name is in
+// the named channel.
+func (s *Server) accountInChannel(name, channel string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[channel]
+	if !ok {
+		return false
+	}
+
+	for member := range ch.members {
+		if strings.EqualFold(member.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelExists reports whether the named channel is open.
+func (s *Server) channelExists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.channels[name]
+	return ok
+}
+
+// sessionChannels returns the channels sess is a member of.
+func (s *Server) sessionChannels(sess *session) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(sess.channels))
+	for name := range sess.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// channelSummary lists every open channel with its member count.
+func (s *Server) channelSummary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, fmt.Sprintf("#%s (%d)", name, len(s.channels[name].members)))
+	}
+
+	return "Channels [" + strings.Join(list, ", ") + "]"
+}
+
+// join adds sess to a channel, confirms it to the client and announces it to
+// the other members.
+func (s *Server) join(sess *session, name string) {
+	s.joinSince(sess, name, 0)
+}
+
+// joinSince is join for a resumed session, since > 0 replays every message
+// newer than since instead of the latest page.
+func (s *Server) joinSince(sess *session, name string, since uint64) {
+	// No message may slip in between the replay and live traffic
+	s.order.Lock()
+	if !s.joinChannel(sess, name) {
+		s.order.Unlock()
+		sess.send(protocol.NewEphemeral(name, fmt.Sprintf("<already in #%s>", name)))
+		return
+	}
+
+	sess.send(&protocol.Envelope{Type: protocol.TypeJoined, Sender: protocol.SenderServer, Room: name, Time: time.Now()})
+	s.sendMembers(sess, name)
+	if since > 0 {
+		s.sendSince(sess, name, since)
+	} else {
+		s.sendHistory(sess, name, 0, s.cfg.HistorySize, false)
+	}
+	s.order.Unlock()
+
+	s.broadcastMsg(sess, protocol.NewNotice(name, fmt.Sprintf("<%s joined #%s>", sess.name, name)))
+	s.announcePresence(sess.name, []string{name})
+}
+
+// resume rejoins the channels a reconnecting client was in. Names that are
+// invalid are skipped, the default channel is always joined.
+func (s *Server) resume(sess *session, r *protocol.Resume) {
+	// The presence goes out with the member lists of the rejoined channels
+	s.mu.Lock()
+	if r.Status == protocol.StatusAway {
+		sess.status = protocol.StatusAway
+	}
+	if r.Voice != "" {
+		sess.voice, _ = normalizeChannel(r.Voice)
+	}
+	s.mu.Unlock()
+
+	s.joinSince(sess, protocol.DefaultChannel, r.LastID)
+
+	for _, name := range r.Channels {
+		name, err := normalizeChannel(name)
+		if err != nil || s.isMember(sess, name) {
+			continue
+		}
+		s.joinSince(sess, name, r.LastID)
+	}
+}
+
+// leave removes sess from a channel, confirms it to the client and announces
+// it to the remaining members.
+func (s *Server) leave(sess *session, name string) {
+	if !s.leaveChannel(sess, name) {
+		sess.send(protocol.NewError(fmt.Sprintf("not in #%s", name)))
+		return
+	}
+
+	sess.send(&protocol.Envelope{Type: protocol.TypeLeft, Sender: protocol.SenderServer, Room: name, Time: time.Now()})
+	s.broadcastMsg(sess, protocol.NewNotice(name, fmt.Sprintf("<%s left #%s>", sess.name, name)))
+	s.announcePresence(sess.name, []string{name})
+}
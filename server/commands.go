@@ -0,0 +1,317 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// Command is a #command users can run from any channel.
+type Command struct {
+	// Name is what follows the "#", matched case-insensitively
+	Name string
+	// Args is checked before Run is called and used for usage lines
+	Args []Arg
+	// Help is the one line description shown by #help
+	Help string
+	// Permission decides who may run the command
+	Permission Permission
+	// Silent commands are neither relayed to the channel nor stored, for
+	// commands whose input is private
+	Silent bool
+	// Public commands answer the whole room through Respond, others only
+	// the caller
+	Public bool
+	// Run executes the command. A returned error is sent to the caller only.
+	Run func(ctx *CommandContext) error
+}
+
+// Arg describes one argument of a Command. Arguments are separated by spaces,
+// double quotes group words into a single argument.
+type Arg struct {
+	Name     string
+	Optional bool
+	// Rest takes the rest of the message as written, from where its first
+	// word starts, quotes and line breaks included. Only the last argument
+	// can be Rest.
+	Rest bool
+}
+
+// Permission is who may run a Command.
+type Permission int
+
+const (
+	PermEveryone Permission = iota
+	// PermAdmin limits a command to the accounts in Config.Admins
+	PermAdmin
+)
+
+// CommandContext is what a running command knows about its invocation.
+type CommandContext struct {
+	// Caller is the account name of whoever ran the command
+	Caller string
+	// Room is the channel the command was sent to
+	Room string
+	// Message is the chat message that invoked the command, ID is only set
+	// when the command isn't Silent
+	Message *protocol.Envelope
+
+	args    map[string]string
+	command *Command
+	server  *Server
+	sess    *session
+}
+
+// Arg returns the named argument, empty if an optional one was left out.
+func (ctx *CommandContext) Arg(name string) string {
+	return ctx.args[name]
+}
+
+// Respond answers the command, publicly or only to the caller depending on
+// Command.Public.
+func (ctx *CommandContext) Respond(text string) {
+	if ctx.command.Public {
+		ctx.Broadcast(text)
+	} else {
+		ctx.Reply(text)
+	}
+}
+
+// Reply sends text to the connection that ran the command only, the client
+// shows it as ephemeral.
+func (ctx *CommandContext) Reply(text string) {
+	ctx.sess.send(protocol.NewEphemeral(ctx.Room, text))
+}
+
+// Broadcast sends text to everyone in the room.
+func (ctx *CommandContext) Broadcast(text string) {
+	ctx.server.broadcastMsg(nil, protocol.NewNotice(ctx.Room, text))
+}
+
+// Usage returns the usage line of the command, e.g. "#join <channel>".
+func (ctx *CommandContext) Usage() string {
+	return ctx.command.usage()
+}
+
+func (cmd *Command) usage() string {
+	var b strings.Builder
+	b.WriteString("#" + cmd.Name)
+
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Optional {
+			fmt.Fprintf(&b, " [%s]", name)
+		} else {
+			fmt.Fprintf(&b, " <%s>", name)
+		}
+	}
+
+	return b.String()
+}
+
+// bind parses line, what follows the command name, into the arguments of
+// cmd.
+func (cmd *Command) bind(line string) (map[string]string, error) {
+	args := map[string]string{}
+
+	rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+	for _, arg := range cmd.Args {
+		if rest == "" {
+			if !arg.Optional {
+				return nil, fmt.Errorf("missing %s", arg.Name)
+			}
+			continue
+		}
+
+		if arg.Rest {
+			args[arg.Name] = rest
+			return args, nil
+		}
+
+		word, remaining, err := nextArg(rest)
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = word
+		rest = strings.TrimLeftFunc(remaining, unicode.IsSpace)
+	}
+
+	if rest != "" {
+		return nil, errors.New("too many arguments")
+	}
+
+	return args, nil
+}
+
+// Register adds a command, replacing any built in one of the same name. It
+// must be called before Start.
+func (s *Server) Register(cmd Command) error {
+	cmd.Name = strings.ToLower(strings.TrimPrefix(cmd.Name, "#"))
+	if cmd.Name == "" || strings.IndexFunc(cmd.Name, unicode.IsSpace) != -1 {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Run == nil {
+		return fmt.Errorf("command #%s has no Run function", cmd.Name)
+	}
+	for i, arg := range cmd.Args {
+		if arg.Rest && i != len(cmd.Args)-1 {
+			return fmt.Errorf("command #%s: only the last argument can be Rest", cmd.Name)
+		}
+	}
+
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+
+	s.commands[cmd.Name] = &cmd
+	return nil
+}
+
+// lookupCommand returns the command msg invokes, nil if msg is an ordinary
+// message. Only messages starting with a registered "#name" are commands.
+func (s *Server) lookupCommand(msg string) *Command {
+	name, ok := commandName(msg)
+	if !ok {
+		return nil
+	}
+
+	s.commandsMu.RLock()
+	defer s.commandsMu.RUnlock()
+
+	return s.commands[name]
+}
+
+// runCommand parses msg against cmd's arguments and runs it on behalf of
+// sess. Commands run synchronously, so replies are queued right behind the
+// message that triggered them.
+func (s *Server) runCommand(sess *session, room string, cmd *Command, msg *protocol.Envelope) {
+	ctx := &CommandContext{
+		Caller:  sess.name,
+		Room:    room,
+		Message: msg,
+		command: cmd,
+		server:  s,
+		sess:    sess,
+	}
+
+	if !s.allowed(sess.name, cmd.Permission) {
+		sess.send(protocol.NewError(fmt.Sprintf("you are not allowed to use #%s", cmd.Name)))
+		return
+	}
+
+	_, line, _ := cutCommand(msg.Body)
+	args, err := cmd.bind(line)
+	ctx.args = args
+	if err != nil {
+		sess.send(protocol.NewError(fmt.Sprintf("%v, usage: %s", err, cmd.usage())))
+		return
+	}
+
+	if err := cmd.Run(ctx); err != nil {
+		sess.send(protocol.NewError(err.Error()))
+	}
+}
+
+func (s *Server) allowed(name string, perm Permission) bool {
+	if perm == PermEveryone {
+		return true
+	}
+
+	for _, admin := range s.cfg.Admins {
+		if strings.EqualFold(admin, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleCommands returns the commands name may run, sorted by name.
+func (s *Server) visibleCommands(name string) []*Command {
+	s.commandsMu.RLock()
+	defer s.commandsMu.RUnlock()
+
+	var list []*Command
+	for _, cmd := range s.commands {
+		if s.allowed(name, cmd.Permission) {
+			list = append(list, cmd)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// commandName returns the lowercased name of the "#name" msg starts with.
+func commandName(msg string) (string, bool) {
+	name, _, ok := cutCommand(msg)
+	return name, ok
+}
+
+// cutCommand splits msg into the lowercased name of the "#name" it starts
+// with and everything after it, untouched.
+func cutCommand(msg string) (name, rest string, ok bool) {
+	msg = strings.TrimLeftFunc(msg, unicode.IsSpace)
+	if !strings.HasPrefix(msg, "#") {
+		return "", "", false
+	}
+
+	name = msg[1:]
+	if i := strings.IndexFunc(name, unicode.IsSpace); i != -1 {
+		name, rest = name[:i], name[i:]
+	}
+	if name == "" {
+		return "", "", false
+	}
+
+	return strings.ToLower(name), rest, true
+}
+
+// nextArg splits the argument s starts with from the rest. Arguments end at
+// whitespace, double quotes group words into one and inside them \" and \\
+// stand for a literal quote and backslash.
+func nextArg(s string) (arg, rest string, err error) {
+	var cur strings.Builder
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quoted && c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			return cur.String(), s[i:], nil
+		default:
+			cur.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return "", "", errors.New("unterminated quote")
+	}
+
+	return cur.String(), "", nil
+}
+
+// unquote returns the text of a Rest argument that is a single quoted
+// argument, and anything else as it is.
+func unquote(rest string) string {
+	if !strings.HasPrefix(rest, `"`) {
+		return rest
+	}
+
+	arg, after, err := nextArg(rest)
+	if err != nil || strings.TrimSpace(after) != "" {
+		return rest
+	}
+	return arg
+}
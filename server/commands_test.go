@@ -0,0 +1,86 @@
+package server
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestCutCommand(t *testing.T) {
+	tests := []struct {
+		msg, name, rest string
+		ok              bool
+	}{
+		{"#help", "help", "", true},
+		{"  #Join random", "join", " random", true},
+		{"#chat\nline one", "chat", "\nline one", true},
+		{"# nothing", "", "", false},
+		{"hello #help", "", "", false},
+	}
+
+	for _, tt := range tests {
+		name, rest, ok := cutCommand(tt.msg)
+		if name != tt.name || rest != tt.rest || ok != tt.ok {
+			t.Errorf("cutCommand(%q) = %q, %q, %v, want %q, %q, %v", tt.msg, name, rest, ok, tt.name, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	join := &Command{Name: "join", Args: []Arg{{Name: "channel"}}}
+	help := &Command{Name: "help", Args: []Arg{{Name: "command", Optional: true}}}
+	chat := &Command{Name: "chat", Args: []Arg{{Name: "prompt", Rest: true}}}
+	dm := &Command{Name: "dm", Args: []Arg{{Name: "user"}, {Name: "message", Rest: true}}}
+
+	tests := []struct {
+		cmd  *Command
+		line string
+		want map[string]string
+		err  bool
+	}{
+		{join, " random", map[string]string{"channel": "random"}, false},
+		{join, ` "two words"`, map[string]string{"channel": "two words"}, false},
+		{join, ` "say \"hi\" \\ bye"`, map[string]string{"channel": `say "hi" \ bye`}, false},
+		{join, "", nil, true},
+		{join, " a b", nil, true},
+		{join, ` "open`, nil, true},
+		{help, "", map[string]string{}, false},
+		{help, "   ", map[string]string{}, false},
+
+		// Rest arguments get the message as written
+		{chat, ` explain fmt.Println("hi") please`, map[string]string{"prompt": `explain fmt.Println("hi") please`}, false},
+		{chat, ` it's 5" long`, map[string]string{"prompt": `it's 5" long`}, false},
+		{chat, " line one\nline two\n\n  - item", map[string]string{"prompt": "line one\nline two\n\n  - item"}, false},
+		{chat, "\n  indented", map[string]string{"prompt": "indented"}, false},
+		{chat, "", nil, true},
+		{dm, ` alice "see you in #random"`, map[string]string{"user": "alice", "message": `"see you in #random"`}, false},
+		{dm, ` "alice" hi  there`, map[string]string{"user": "alice", "message": "hi  there"}, false},
+		{dm, " alice", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.cmd.bind(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("#%s bind(%q) error = %v, want error %v", tt.cmd.Name, tt.line, err, tt.err)
+			continue
+		}
+		if err == nil && !maps.Equal(got, tt.want) {
+			t.Errorf("#%s bind(%q) = %q, want %q", tt.cmd.Name, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`"see you in #random"`, "see you in #random"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"a" "b"`, `"a" "b"`},
+		{`"unterminated`, `"unterminated`},
+		{`plain "quoted" words`, `plain "quoted" words`},
+	}
+
+	for _, tt := range tests {
+		if got := unquote(tt.in); got != tt.want {
+			t.Errorf("unquote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// handshakeTimeout is how long a new connection has to send its hello.
+const handshakeTimeout = 10 * time.Second
+
+func (s *Server) handleConnections(conn net.Conn) {
+	// Read the handshake and store connected user display name
+	dec := protocol.NewDecoder(conn)
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	hello, err := dec.Decode()
+	if err != nil {
+		fmt.Printf("error reading handshake: %q\n", err)
+		conn.Close()
+		return
+	}
+
+	// Handshake replies are written before the session writer exists, a
+	// client that stops reading must not hold the handler forever
+	reply := func(env *protocol.Envelope) error {
+		conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+		return protocol.WriteFrame(conn, env)
+	}
+
+	if hello.Type != protocol.TypeHello || hello.Version != protocol.Version {
+		fmt.Printf("rejecting handshake from %s: type %q version %d\n", conn.RemoteAddr().String(), hello.Type, hello.Version)
+		reply(protocol.NewError(fmt.Sprintf("unsupported protocol version, server speaks v%d", protocol.Version)))
+		conn.Close()
+		return
+	}
+
+	acc, token, err := s.authenticate(strings.TrimSpace(hello.Sender), hello.Auth)
+	if err != nil {
+		fmt.Printf("login failed for %q from %s: %v\n", hello.Sender, conn.RemoteAddr().String(), err)
+		reply(protocol.NewError(err.Error()))
+		conn.Close()
+		return
+	}
+
+	// Use the name as registered so case variations can't pose as someone else
+	display_name := acc.Name
+
+	welcome := &protocol.Envelope{Version: protocol.Version, Type: protocol.TypeWelcome, Sender: display_name, Auth: &protocol.Auth{Token: token}, MaxMessageSize: s.cfg.MaxMessageSize, Moderator: s.isModerator(display_name), Time: time.Now()}
+	if err := reply(welcome); err != nil {
+		fmt.Printf("error sending welcome: %q\n", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	sess := newSession(conn, display_name, s.cfg.SendQueueSize, s.cfg.SlowConsumer, s.cfg.WriteTimeout)
+	sess.onDrop = func() { s.dropped.Add(1) }
+	sess.onKick = func() { s.slowDisconnects.Add(1) }
+	go sess.writeLoop()
+
+	defer func() {
+		conn.Close()
+		sess.stop()
+		s.conns.Delete(conn.RemoteAddr().String())
+		for _, name := range s.sessionChannels(sess) {
+			s.leaveChannel(sess, name)
+			// Everyone is leaving during shutdown, no need to announce it
+			if !s.closing.Load() {
+				s.broadcastMsg(nil, protocol.NewNotice(name, fmt.Sprintf("<%s left the room>", display_name)))
+				s.announcePresence(display_name, []string{name})
+			}
+		}
+		fmt.Printf("\n%s | %s left the room\n", display_name, conn.RemoteAddr().String())
+		if s.cfg.Hooks.OnDisconnect != nil {
+			s.cfg.Hooks.OnDisconnect(display_name, conn.RemoteAddr().String())
+		}
+	}()
+
+	s.conns.Store(conn.RemoteAddr().String(), sess)
+	if s.closing.Load() {
+		// Shutdown may have missed this session, say goodbye here instead
+		sess.goodbye(protocol.ReasonShutdown, "Server shut down")
+		select {
+		case <-sess.finished:
+		case <-time.After(s.cfg.WriteTimeout):
+		}
+		return
+	}
+	fmt.Printf("\nNew Connection: %s | %s\n\n", display_name, conn.RemoteAddr().String())
+	if hello.Resume != nil {
+		s.resume(sess, hello.Resume)
+	} else {
+		s.join(sess, protocol.DefaultChannel)
+	}
+	if s.cfg.Hooks.OnConnect != nil {
+		s.cfg.Hooks.OnConnect(display_name, conn.RemoteAddr().String())
+	}
+
+	for {
+		env, err := dec.Decode()
+		var tooLarge *protocol.FrameTooLargeError
+		if errors.As(err, &tooLarge) {
+			// The decoder skipped the frame, the connection is still in sync
+			fmt.Printf("%s | %s sent %d bytes, dropped\n", display_name, conn.RemoteAddr().String(), tooLarge.Size)
+			sess.send(s.tooLargeError(tooLarge.Size))
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+
+		if len(env.Body) > s.cfg.MaxMessageSize {
+			sess.send(s.tooLargeError(len(env.Body)))
+			continue
+		}
+
+		if env.Type == protocol.TypeDirect {
+			s.sendDirect(sess, env.To, env.Body)
+			continue
+		}
+
+		if env.Type == protocol.TypePresence {
+			s.setPresence(sess, env)
+			continue
+		}
+
+		if env.Type == protocol.TypeTyping {
+			s.relayTyping(sess, env)
+			continue
+		}
+
+		if env.Type == protocol.TypeEdit {
+			s.editMessage(sess, env)
+			continue
+		}
+
+		if env.Type == protocol.TypeDelete {
+			s.deleteMessage(sess, env)
+			continue
+		}
+
+		if env.Type == protocol.TypeReact {
+			s.react(sess, env)
+			continue
+		}
+
+		if env.Type != protocol.TypeChat && env.Type != protocol.TypeHistory && env.Type != protocol.TypeThread {
+			sess.send(protocol.NewError(fmt.Sprintf("unexpected message type %q", env.Type)))
+			continue
+		}
+
+		room, err := normalizeChannel(env.Room)
+		if err != nil {
+			sess.send(protocol.NewError(err.Error()))
+			continue
+		}
+
+		if !s.isMember(sess, room) {
+			sess.send(protocol.NewError(fmt.Sprintf("not in #%s, use #join %s first", room, room)))
+			continue
+		}
+
+		if env.Type == protocol.TypeHistory {
+			s.sendHistory(sess, room, env.ID, s.cfg.HistorySize, true)
+			continue
+		}
+
+		if env.Type == protocol.TypeThread {
+			s.sendThread(sess, room, env.ID)
+			continue
+		}
+
+		// The sender is always the name from the handshake, never what the client claims
+		msg := protocol.NewChat(room, display_name, env.Body)
+		if env.ReplyTo != 0 {
+			quote, err := s.replyQuote(room, env.ReplyTo)
+			if err != nil {
+				sess.send(protocol.NewError(err.Error()))
+				continue
+			}
+			msg.ReplyTo, msg.Quote = env.ReplyTo, quote
+		}
+
+		// Silent commands such as #dm must never reach the channel
+		cmd := s.lookupCommand(env.Body)
+		if cmd != nil && cmd.Silent {
+			s.runCommand(sess, room, cmd, msg)
+			continue
+		}
+
+		fmt.Printf("#%s %s: %s | %s\n", room, display_name, msg.Body, conn.RemoteAddr().String())
+		s.broadcastMsg(sess, msg)
+		if env.Ref != "" {
+			sess.send(&protocol.Envelope{Type: protocol.TypeSent, ID: msg.ID, Room: room, Ref: env.Ref, Time: msg.Time})
+		}
+		if s.cfg.Hooks.OnMessage != nil {
+			s.cfg.Hooks.OnMessage(msg)
+		}
+
+		// Commands run on this goroutine right after the message went out, so
+		// their replies always follow it
+		if cmd != nil {
+			s.runCommand(sess, room, cmd, msg)
+		} else if p := s.mentionedPersona(msg.Body); p != nil {
+			if err := s.ask(sess, room, p, msg.Body); err != nil {
+				sess.send(protocol.NewError(err.Error()))
+			}
+		}
+	}
+}
+
+// tooLargeError is the reply to a message over MaxMessageSize.
+func (s *Server) tooLargeError(size int) *protocol.Envelope {
+	return protocol.NewError(fmt.Sprintf("message too large (%d bytes, max %d)", size, s.cfg.MaxMessageSize))
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// sessionsNamed returns every connection logged in as the account name.
+func (s *Server) sessionsNamed(name string) []*session {
+	var list []*session
+
+	s.conns.Range(func(_, value any) bool {
+		sess := value.(*session)
+		if strings.EqualFold(sess.name, name) {
+			list = append(list, sess)
+		}
+		return true
+	})
+
+	return list
+}
+
+// sendDirect delivers a private message from sess to every connection of the
+// user to, and to the other connections of the sender so all its clients show
+// the conversation. Direct messages are not stored.
+func (s *Server) sendDirect(sess *session, to, body string) {
+	to = strings.TrimPrefix(strings.TrimSpace(to), "@")
+	if to == "" || strings.TrimSpace(body) == "" {
+		sess.send(protocol.NewError(`usage: #dm <user> "message"`))
+		return
+	}
+
+	if strings.EqualFold(to, sess.name) {
+		sess.send(protocol.NewError("you can't message yourself"))
+		return
+	}
+
+	targets := s.sessionsNamed(to)
+	if len(targets) == 0 {
+		if _, err := s.accounts.Get(to); errors.Is(err, ErrNoAccount) {
+			sess.send(protocol.NewError(fmt.Sprintf("no user named %s", to)))
+		} else {
+			sess.send(protocol.NewError(fmt.Sprintf("%s is not online", to)))
+		}
+		return
+	}
+
+	msg := protocol.NewDirect(sess.name, targets[0].name, body)
+
+	s.order.Lock()
+	frame, err := protocol.Encode(msg)
+	if err == nil {
+		for _, target := range append(targets, s.sessionsNamed(sess.name)...) {
+			if target != sess {
+				target.sendFrame(frame)
+			}
+		}
+	}
+	s.order.Unlock()
+
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	fmt.Printf("@%s -> @%s | %s\n", sess.name, msg.To, sess.conn.RemoteAddr().String())
+}
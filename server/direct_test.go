@@ -0,0 +1,38 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// Direct messages reach the recipient and the sender's other connections,
+// nobody else.
+func TestDirectMessage(t *testing.T) {
+	s := startServer(t, server.Config{})
+	alice := dial(t, s, "alice", true, false)
+	aliceToo := dial(t, s, "alice", false, false)
+	bob := dial(t, s, "bob", true, false)
+	carol := dial(t, s, "carol", true, false)
+
+	direct := func(env *protocol.Envelope) bool { return env.Type == protocol.TypeDirect }
+
+	alice.SendDirect("bob", "psst")
+	for _, c := range []*client.Client{bob, aliceToo} {
+		dm := waitFor(t, c, direct)
+		if dm.Sender != "alice" || dm.To != "bob" || dm.Body != "psst" {
+			t.Errorf("%s got %s -> %s %q, want alice -> bob psst", c.DisplayName(), dm.Sender, dm.To, dm.Body)
+		}
+	}
+
+	// Frames arrive in order, so a DM to carol would come before this
+	alice.Send(protocol.DefaultChannel, "done")
+	if env := waitFor(t, carol, func(env *protocol.Envelope) bool { return direct(env) || chatWith("done")(env) }); env.Type == protocol.TypeDirect {
+		t.Errorf("carol got the direct message %q", env.Body)
+	}
+
+	alice.SendDirect("nobody", "hello?")
+	waitFor(t, alice, errorWith("no user named nobody"))
+}
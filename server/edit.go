@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// isModerator reports whether the account name may change anyone's messages.
+func (s *Server) isModerator(name string) bool {
+	if s.allowed(name, PermAdmin) {
+		return true
+	}
+
+	for _, mod := range s.cfg.Moderators {
+		if strings.EqualFold(mod, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedChat returns the stored chat message env refers to, if sess is in
+// its room and it can still change. s.editsMu must be held.
+func (s *Server) storedChat(sess *session, env *protocol.Envelope) (*protocol.Envelope, error) {
+	room, err := normalizeChannel(env.Room)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isMember(sess, room) {
+		return nil, fmt.Errorf("not in #%s, use #join %s first", room, room)
+	}
+
+	msg, err := s.store.Get(room, env.ID)
+	if errors.Is(err, ErrNotStored) {
+		return nil, fmt.Errorf("no message %d in #%s", env.ID, room)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case msg.Type != protocol.TypeChat:
+		return nil, errors.New("only chat messages can be changed")
+	case msg.Deleted:
+		return nil, errors.New("that message was deleted")
+	case msg.Streaming:
+		return nil, errors.New("that message is still being written")
+	}
+
+	return msg, nil
+}
+
+// changeable returns the stored message env refers to if sess may edit or
+// delete it. s.editsMu must be held.
+func (s *Server) changeable(sess *session, env *protocol.Envelope) (*protocol.Envelope, error) {
+	msg, err := s.storedChat(sess, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(msg.Sender, sess.name) && !s.isModerator(sess.name) {
+		return nil, errors.New("you can only change your own messages")
+	}
+
+	return msg, nil
+}
+
+// editMessage replaces the text of a stored message and tells its room.
+func (s *Server) editMessage(sess *session, env *protocol.Envelope) {
+	if strings.TrimSpace(env.Body) == "" {
+		sess.send(protocol.NewError("a message can't be edited to nothing, delete it instead"))
+		return
+	}
+
+	s.editsMu.Lock()
+	defer s.editsMu.Unlock()
+
+	msg, err := s.changeable(sess, env)
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	edited := *msg
+	edited.Body = env.Body
+	edited.Edited = true
+	if err := s.store.Update(&edited); err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	edit := protocol.NewEdit(msg.Room, msg.ID, edited.Body)
+	edit.Sender = sess.name
+	edit.Edited = true
+	s.relay(nil, edit)
+
+	fmt.Printf("#%s %s edited message %d\n", msg.Room, sess.name, msg.ID)
+}
+
+// deleteMessage replaces a stored message by a tombstone and tells its room.
+func (s *Server) deleteMessage(sess *session, env *protocol.Envelope) {
+	s.editsMu.Lock()
+	defer s.editsMu.Unlock()
+
+	msg, err := s.changeable(sess, env)
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	tombstone := *msg
+	tombstone.Body = ""
+	tombstone.Edited = false
+	tombstone.Deleted = true
+	tombstone.Reactions = nil
+	tombstone.Quote = nil
+	if err := s.store.Update(&tombstone); err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	del := protocol.NewDelete(msg.Room, msg.ID)
+	del.Sender = sess.name
+	s.relay(nil, del)
+
+	fmt.Printf("#%s %s deleted message %d\n", msg.Room, sess.name, msg.ID)
+}
@@ -0,0 +1,64 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// errorWith matches an error frame with body.
+func errorWith(body string) func(*protocol.Envelope) bool {
+	return func(env *protocol.Envelope) bool {
+		return env.Type == protocol.TypeError && env.Body == body
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	hold := make(chan struct{})
+	stub := &streamProvider{deltas: []string{"thinking", " done"}, hold: hold}
+	s := startServer(t, server.Config{
+		Moderators: []string{"mod"},
+		AIProvider: stub,
+		Personas:   []server.Persona{{Name: "Helper", Command: "ask"}},
+	})
+	t.Cleanup(func() { close(hold) })
+
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	mod := dial(t, s, "mod", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	room := protocol.DefaultChannel
+	alice.Send(room, "hello")
+	id := waitFor(t, bob, chatWith("hello")).ID
+
+	// Only the author or a moderator may change a message
+	bob.Edit(room, id, "changed")
+	waitFor(t, bob, errorWith("you can only change your own messages"))
+	bob.Delete(room, id)
+	waitFor(t, bob, errorWith("you can only change your own messages"))
+
+	mod.Delete(room, id)
+	for _, c := range []*client.Client{alice, bob, mod} {
+		del := waitFor(t, c, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeDelete })
+		if del.ID != id || del.Sender != "mod" {
+			t.Errorf("%s got delete of %d by %s, want %d by mod", c.DisplayName(), del.ID, del.Sender, id)
+		}
+	}
+
+	alice.Edit(room, id, "back")
+	waitFor(t, alice, errorWith("that message was deleted"))
+
+	// Not even a moderator may change an answer that is still being written
+	alice.Send(room, "#ask something")
+	answer := waitFor(t, mod, botAnswer)
+	if !answer.Streaming {
+		t.Fatalf("answer = %+v, want it streaming", answer)
+	}
+	mod.Edit(room, answer.ID, "changed")
+	waitFor(t, mod, errorWith("that message is still being written"))
+	mod.Delete(room, answer.ID)
+	waitFor(t, mod, errorWith("that message is still being written"))
+}
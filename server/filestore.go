@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// FileStore is an append-only log of JSON encoded messages, one per line.
+// An updated message is appended again, the later line wins. The whole log is
+// indexed in memory when opened.
+type FileStore struct {
+	*MemoryStore
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileStore opens or creates the log at path and loads its contents.
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+
+	mem := NewMemoryStore(0)
+
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 0, 64*1024), protocol.MaxFrameSize+1024)
+
+	line := 0
+	for sc.Scan() {
+		line++
+
+		msg := &protocol.Envelope{}
+		if err := json.Unmarshal(sc.Bytes(), msg); err != nil {
+			// A crash can leave a partial last line behind, skip anything unreadable
+			fmt.Printf("skipping history line %d: %v\n", line, err)
+			continue
+		}
+		if msg.ID != 0 && msg.ID <= mem.LastID() {
+			mem.Update(msg)
+		} else {
+			mem.Append(msg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading history file: %w", err)
+	}
+
+	// End a partial last line, so the next message starts a line of its own
+	if err := terminateLine(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error repairing history file: %w", err)
+	}
+
+	return &FileStore{MemoryStore: mem, file: file}, nil
+}
+
+// terminateLine appends a newline to file unless it is empty or already
+// ends with one.
+func terminateLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
+
+func (f *FileStore) Append(msg *protocol.Envelope) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error encoding history entry: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing history entry: %w", err)
+	}
+
+	return f.MemoryStore.Append(msg)
+}
+
+func (f *FileStore) Update(msg *protocol.Envelope) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error encoding history entry: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.MemoryStore.Update(msg); err != nil {
+		return err
+	}
+
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing history entry: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes the log to disk and closes it.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+
+	return f.file.Close()
+}
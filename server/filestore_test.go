@@ -0,0 +1,87 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// A reopened log holds every message as last updated, in order.
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 4; id++ {
+		if err := store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: id, Body: "original"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "random", ID: 5, Body: "elsewhere"})
+	store.Update(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: 2, Body: "edited", Edited: true})
+	store.Update(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: 3, Deleted: true})
+	store.Update(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: 2, Body: "edited again", Edited: true})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash can leave half a line at the end
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"type":"chat","room":"general","id":6,"bo`)
+	file.Close()
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, more, err := store.Before("general", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(msgs), []uint64{1, 2, 3, 4}) || more {
+		t.Fatalf("reloaded #general = %v, %v, want [1 2 3 4], false", ids(msgs), more)
+	}
+
+	tests := []struct {
+		msg     *protocol.Envelope
+		body    string
+		edited  bool
+		deleted bool
+	}{
+		{msgs[0], "original", false, false},
+		{msgs[1], "edited again", true, false},
+		{msgs[2], "", false, true},
+		{msgs[3], "original", false, false},
+	}
+	for _, tt := range tests {
+		if tt.msg.Body != tt.body || tt.msg.Edited != tt.edited || tt.msg.Deleted != tt.deleted {
+			t.Errorf("message %d = %q edited %v deleted %v, want %q edited %v deleted %v", tt.msg.ID, tt.msg.Body, tt.msg.Edited, tt.msg.Deleted, tt.body, tt.edited, tt.deleted)
+		}
+	}
+
+	if got := store.LastID(); got != 5 {
+		t.Errorf("LastID() = %d, want 5", got)
+	}
+
+	// Messages appended after the partial line survive the next reload
+	store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: 6, Body: "new"})
+	store.Close()
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if msg, err := store.Get("general", 6); err != nil || msg.Body != "new" {
+		t.Errorf("Get(6) after reload = %v, %v, want new", msg, err)
+	}
+}
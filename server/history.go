@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// maxHistoryRequest caps how many messages a single #history may ask for
+const maxHistoryRequest = 500
+
+// sendHistory sends sess up to limit stored messages of room older than
+// before. page marks the reply as the answer to a scroll-back request. Pages
+// too large for one frame lose their oldest messages, the client will page
+// for them again.
+func (s *Server) sendHistory(sess *session, room string, before uint64, limit int, page bool) {
+	msgs, more, err := s.store.Before(room, before, limit)
+	if err != nil {
+		fmt.Println(err)
+		sess.send(protocol.NewError("history unavailable"))
+		return
+	}
+
+	reply := &protocol.Envelope{
+		Type:    protocol.TypeHistory,
+		Sender:  protocol.SenderServer,
+		Room:    room,
+		Time:    time.Now(),
+		History: s.withQuotes(room, msgs),
+		More:    more,
+	}
+	if page {
+		reply.ID = before
+	}
+
+	for len(reply.History) > 0 {
+		if _, err := protocol.Encode(reply); err != protocol.ErrFrameTooLarge {
+			break
+		}
+		reply.History = reply.History[1:]
+		reply.More = true
+	}
+
+	if err := sess.send(reply); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// sendSince replays every stored message of room newer than since, in as many
+// frames as needed. At most maxHistoryRequest messages are resent, anything
+// older stays reachable through #history.
+func (s *Server) sendSince(sess *session, room string, since uint64) {
+	msgs, more, err := s.store.Before(room, 0, maxHistoryRequest)
+	if err != nil {
+		fmt.Println(err)
+		sess.send(protocol.NewError("history unavailable"))
+		return
+	}
+
+	first := 0
+	for first < len(msgs) && msgs[first].ID <= since {
+		first++
+	}
+	msgs = s.withQuotes(room, msgs[first:])
+
+	if first == 0 && more {
+		sess.send(protocol.NewEphemeral(room, fmt.Sprintf("<too many messages missed in #%s, use #history to see older ones>", room)))
+	}
+
+	for len(msgs) > 0 {
+		reply := &protocol.Envelope{
+			Type:    protocol.TypeHistory,
+			Sender:  protocol.SenderServer,
+			Room:    room,
+			Time:    time.Now(),
+			History: msgs,
+		}
+
+		// Halve the batch until it fits a frame, a single message always does
+		for len(reply.History) > 1 {
+			if _, err := protocol.Encode(reply); err != protocol.ErrFrameTooLarge {
+				break
+			}
+			reply.History = reply.History[:len(reply.History)/2]
+		}
+
+		if err := sess.send(reply); err != nil {
+			fmt.Println(err)
+			return
+		}
+		msgs = msgs[len(reply.History):]
+	}
+}
@@ -0,0 +1,178 @@
+package server_test
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// With the default config, clients that keep up get every message exactly
+// once and in order, while one that stopped reading is disconnected instead
+// of holding everyone else up.
+func TestLoadWithSlowConsumer(t *testing.T) {
+	const (
+		clients = 6
+		// Senders carry on past messages until the slow client's socket
+		// buffers and queue are full, which depends on the kernel
+		messages    = 100
+		maxMessages = 2000
+		pace        = time.Millisecond
+	)
+
+	cfg := server.DefaultConfig()
+	cfg.TCPAddr = "127.0.0.1:0"
+	cfg.TokenAddr = ""
+	s := startServer(t, cfg)
+
+	slow := dialRaw(t, s, "slow")
+
+	fast := make([]*client.Client, clients)
+	for i := range fast {
+		fast[i] = dial(t, s, fmt.Sprintf("fast%d", i), true, false)
+	}
+	// Everyone is in the channel once the last join was announced to all
+	last := fast[clients-1].DisplayName()
+	for _, c := range fast[:clients-1] {
+		waitFor(t, c, func(env *protocol.Envelope) bool {
+			return env.Type == protocol.TypeNotice && strings.Contains(env.Body, last)
+		})
+	}
+
+	// Bodies close to the size limit fill the slow client's buffers quickly
+	padding := strings.Repeat("x", cfg.MaxMessageSize-100)
+
+	// The slow client reads again once it was cut off, its stream must end
+	// with the reason before the write timeout gives up on it
+	goodbye := make(chan *protocol.Envelope, 1)
+	go func() {
+		for s.Stats().SlowDisconnects == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var last *protocol.Envelope
+		slow.SetReadDeadline(time.Now().Add(cfg.WriteTimeout))
+		dec := protocol.NewDecoder(slow)
+		for {
+			env, err := dec.Decode()
+			if err != nil {
+				break
+			}
+			last = env
+		}
+		goodbye <- last
+	}()
+
+	var wg sync.WaitGroup
+	problems := make(chan string, 2*clients)
+	for _, c := range fast {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			seq := 0
+			for ; seq < maxMessages && (seq < messages || s.Stats().SlowDisconnects == 0); seq++ {
+				if err := c.Send(protocol.DefaultChannel, fmt.Sprintf("load %d %s", seq, padding)); err != nil {
+					problems <- fmt.Sprintf("%s: %v", c.DisplayName(), err)
+					return
+				}
+				time.Sleep(pace)
+			}
+			c.Send(protocol.DefaultChannel, fmt.Sprintf("done %d", seq))
+		}()
+		go func() {
+			defer wg.Done()
+			if problem := receiveLoad(c, clients-1); problem != "" {
+				problems <- problem
+			}
+		}()
+	}
+	wg.Wait()
+	close(problems)
+
+	for problem := range problems {
+		t.Error(problem)
+	}
+
+	if got := s.Stats().SlowDisconnects; got != 1 {
+		t.Fatalf("%d slow consumers disconnected, want 1", got)
+	}
+
+	final := <-goodbye
+	if final == nil || final.Type != protocol.TypeGoodbye || final.Reason != protocol.ReasonSlowConsumer {
+		t.Errorf("slow client's last frame = %+v, want a slow consumer goodbye", final)
+	}
+}
+
+// dialRaw logs name in over a bare connection that is never read from after
+// the welcome.
+func dialRaw(t *testing.T, s *server.Server, name string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := protocol.WriteFrame(conn, protocol.NewHello(name, &protocol.Auth{Password: testPassword, Register: true})); err != nil {
+		t.Fatal(err)
+	}
+	welcome, err := protocol.NewDecoder(conn).Decode()
+	if err != nil || welcome.Type != protocol.TypeWelcome {
+		t.Fatalf("%s: handshake failed: %v %+v", name, err, welcome)
+	}
+
+	return conn
+}
+
+// receiveLoad reads the load messages arriving at c until the given number
+// of senders said they are done, and describes the first message lost,
+// duplicated or out of order.
+func receiveLoad(c *client.Client, senders int) string {
+	next := map[string]int{}
+	done := 0
+	timeout := time.After(30 * time.Second)
+
+	for done < senders {
+		select {
+		case env, ok := <-c.Messages():
+			if !ok {
+				return fmt.Sprintf("%s: connection closed", c.DisplayName())
+			}
+			if env.Type == protocol.TypeGoodbye {
+				return fmt.Sprintf("%s: disconnected: %s", c.DisplayName(), env.Body)
+			}
+			if env.Type != protocol.TypeChat {
+				continue
+			}
+
+			var seq int
+			switch fields := strings.Fields(env.Body); fields[0] {
+			case "load":
+				seq, _ = strconv.Atoi(fields[1])
+				if want := next[env.Sender]; seq != want {
+					return fmt.Sprintf("%s: got #%d from %s, want #%d", c.DisplayName(), seq, env.Sender, want)
+				}
+				next[env.Sender] = seq + 1
+			case "done":
+				seq, _ = strconv.Atoi(fields[1])
+				if got := next[env.Sender]; got != seq {
+					return fmt.Sprintf("%s: got %d messages from %s, want %d", c.DisplayName(), got, env.Sender, seq)
+				}
+				done++
+			}
+
+		case <-timeout:
+			return fmt.Sprintf("%s: timed out", c.DisplayName())
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// members returns the member list of the named channel, one entry per user
+// sorted by name. A user connected more than once is online if any of its
+// connections is, and in voice if any of them is.
+func (s *Server) members(name string) []protocol.Member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		return nil
+	}
+
+	byName := map[string]*protocol.Member{}
+	for sess := range ch.members {
+		key := strings.ToLower(sess.name)
+		m := byName[key]
+		if m == nil {
+			m = &protocol.Member{Name: sess.name, Status: sess.status}
+			byName[key] = m
+		}
+		if sess.status == protocol.StatusOnline {
+			m.Status = protocol.StatusOnline
+		}
+		if sess.voice == name {
+			m.Voice = true
+		}
+	}
+
+	list := make([]protocol.Member, 0, len(byName))
+	for _, m := range byName {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+
+	return list
+}
+
+// sendMembers sends sess the member list of the named channel.
+func (s *Server) sendMembers(sess *session, name string) {
+	sess.send(&protocol.Envelope{Type: protocol.TypeMembers, Sender: protocol.SenderServer, Room: name, Members: s.members(name), Time: time.Now()})
+}
+
+// announcePresence tells each of rooms how the user name is doing there, or
+// that it is gone.
+func (s *Server) announcePresence(name string, rooms []string) {
+	for _, room := range rooms {
+		m := protocol.Member{Name: name, Status: protocol.StatusOffline}
+		for _, member := range s.members(room) {
+			if strings.EqualFold(member.Name, name) {
+				m = member
+				break
+			}
+		}
+
+		s.relay(nil, protocol.NewPresence(room, m))
+	}
+}
+
+// setPresence applies a presence frame from sess and announces the change to
+// every channel it is in.
+func (s *Server) setPresence(sess *session, env *protocol.Envelope) {
+	switch env.Status {
+	case "", protocol.StatusOnline, protocol.StatusAway:
+	default:
+		sess.send(protocol.NewError(fmt.Sprintf("unknown status %q", env.Status)))
+		return
+	}
+
+	voice := ""
+	if env.Voice {
+		room, err := normalizeChannel(env.Room)
+		if err != nil {
+			sess.send(protocol.NewError(err.Error()))
+			return
+		}
+		if !s.isMember(sess, room) {
+			sess.send(protocol.NewError(fmt.Sprintf("not in #%s, use #join %s first", room, room)))
+			return
+		}
+		voice = room
+	}
+
+	s.mu.Lock()
+	if env.Status != "" {
+		sess.status = env.Status
+	}
+	sess.voice = voice
+	s.mu.Unlock()
+
+	s.announcePresence(sess.name, s.sessionChannels(sess))
+}
+
+// relayTyping passes a typing frame of sess on to its channel, or to the
+// user in To for a direct conversation. Typing to someone offline is dropped
+// without an error, the frames keep coming while the user types.
+func (s *Server) relayTyping(sess *session, env *protocol.Envelope) {
+	if env.To != "" {
+		to := strings.TrimPrefix(strings.TrimSpace(env.To), "@")
+		targets := s.sessionsNamed(to)
+		if len(targets) == 0 || strings.EqualFold(to, sess.name) {
+			return
+		}
+
+		typing := &protocol.Envelope{Type: protocol.TypeTyping, Sender: sess.name, To: targets[0].name, Active: env.Active, Time: time.Now()}
+		for _, target := range targets {
+			target.send(typing)
+		}
+		return
+	}
+
+	room, err := normalizeChannel(env.Room)
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+	if !s.isMember(sess, room) {
+		sess.send(protocol.NewError(fmt.Sprintf("not in #%s, use #join %s first", room, room)))
+		return
+	}
+
+	s.relay(sess, protocol.NewTyping(room, sess.name, env.Active))
+}
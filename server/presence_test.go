@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// presenceOf matches the presence of name in room.
+func presenceOf(name, room string) func(*protocol.Envelope) bool {
+	return func(env *protocol.Envelope) bool {
+		return env.Type == protocol.TypePresence && env.Sender == name && env.Room == room
+	}
+}
+
+// Other members learn when a user joins, leaves or changes its status.
+func TestPresence(t *testing.T) {
+	s := startServer(t, server.Config{})
+	alice := dial(t, s, "alice", true, false)
+	alice.Send(protocol.DefaultChannel, "#join games")
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeJoined && env.Room == "games" })
+
+	bob := dial(t, s, "bob", true, false)
+	if p := waitFor(t, alice, presenceOf("bob", protocol.DefaultChannel)); p.Status != protocol.StatusOnline {
+		t.Errorf("bob connected as %s, want online", p.Status)
+	}
+
+	bob.Send(protocol.DefaultChannel, "#join games")
+	if p := waitFor(t, alice, presenceOf("bob", "games")); p.Status != protocol.StatusOnline {
+		t.Errorf("bob joined as %s, want online", p.Status)
+	}
+	members := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeMembers && env.Room == "games" })
+	want := []protocol.Member{{Name: "alice", Status: protocol.StatusOnline}, {Name: "bob", Status: protocol.StatusOnline}}
+	if !reflect.DeepEqual(members.Members, want) {
+		t.Errorf("members of #games = %v, want %v", members.Members, want)
+	}
+
+	// A status change reaches every channel the user is in
+	bob.SetPresence(protocol.StatusAway, "")
+	away := map[string]protocol.Status{}
+	for len(away) < 2 {
+		p := waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypePresence && env.Sender == "bob" })
+		away[p.Room] = p.Status
+	}
+	if want := map[string]protocol.Status{protocol.DefaultChannel: protocol.StatusAway, "games": protocol.StatusAway}; !reflect.DeepEqual(away, want) {
+		t.Errorf("bob after going away = %v, want %v", away, want)
+	}
+
+	bob.Send(protocol.DefaultChannel, "#leave games")
+	if p := waitFor(t, alice, presenceOf("bob", "games")); p.Status != protocol.StatusOffline {
+		t.Errorf("bob left #games as %s, want offline", p.Status)
+	}
+
+	bob.Close()
+	if p := waitFor(t, alice, presenceOf("bob", protocol.DefaultChannel)); p.Status != protocol.StatusOffline {
+		t.Errorf("bob disconnected as %s, want offline", p.Status)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Roles of the messages in a CompletionRequest.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// AIMessage is one message of a conversation with a bot.
+type AIMessage struct {
+	Role    string
+	Content string
+}
+
+// CompletionRequest asks a Provider to continue a conversation.
+type CompletionRequest struct {
+	Model string
+	// Temperature is left to the provider when nil
+	Temperature *float64
+	Messages    []AIMessage
+}
+
+// Provider answers prompts to the bot personas. Implementations must be
+// safe for concurrent use.
+type Provider interface {
+	// Complete returns the next assistant message of the conversation
+	Complete(ctx context.Context, req CompletionRequest) (string, error)
+}
+
+// StreamingProvider is a Provider that can hand out the answer piece by
+// piece while it is generated. Bots of other providers show up all at once.
+type StreamingProvider interface {
+	Provider
+	// Stream calls onDelta with every new piece of the answer and returns
+	// the whole answer
+	Stream(ctx context.Context, req CompletionRequest, onDelta func(delta string)) (string, error)
+}
+
+// OpenAIProvider talks to the OpenAI chat completions API, or any endpoint
+// compatible with it such as a local llama.cpp or Ollama server.
+type OpenAIProvider struct {
+	client openai.Client
+}
+
+// NewOpenAIProvider returns a provider using apiKey. An empty baseURL means
+// api.openai.com, local endpoints usually don't need a key.
+func NewOpenAIProvider(apiKey, baseURL string) *OpenAIProvider {
+	opts := []option.RequestOption{option.WithAPIKey(apiKey)}
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+
+	return &OpenAIProvider{client: openai.NewClient(opts...)}
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	completion, err := p.client.Chat.Completions.New(ctx, openAIParams(req))
+	if err != nil {
+		return "", fmt.Errorf("error sending request to OpenAI API: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", errors.New("OpenAI API returned no choices")
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
+
+func (p *OpenAIProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(delta string)) (string, error) {
+	stream := p.client.Chat.Completions.NewStreaming(ctx, openAIParams(req))
+	defer stream.Close()
+
+	var answer strings.Builder
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		answer.WriteString(delta)
+		onDelta(delta)
+	}
+	if err := stream.Err(); err != nil {
+		return answer.String(), fmt.Errorf("error streaming from OpenAI API: %w", err)
+	}
+
+	return answer.String(), nil
+}
+
+func openAIParams(req CompletionRequest) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{Model: req.Model}
+	if req.Temperature != nil {
+		params.Temperature = openai.Float(*req.Temperature)
+	}
+
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			params.Messages = append(params.Messages, openai.SystemMessage(m.Content))
+		case RoleAssistant:
+			params.Messages = append(params.Messages, openai.AssistantMessage(m.Content))
+		default:
+			params.Messages = append(params.Messages, openai.UserMessage(m.Content))
+		}
+	}
+
+	return params
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+const (
+	// maxReactions is how many different emoji a message can collect
+	maxReactions = 20
+	// maxEmojiSize is the longest emoji in bytes, enough for sequences
+	// joined with zero width joiners
+	maxEmojiSize = 32
+)
+
+// validEmoji reports whether e looks like an emoji rather than text: short,
+// without spaces and not starting with an ASCII character.
+func validEmoji(e string) bool {
+	if e == "" || len(e) > maxEmojiSize || !utf8.ValidString(e) {
+		return false
+	}
+
+	first, _ := utf8.DecodeRuneInString(e)
+	if first < utf8.RuneSelf {
+		return false
+	}
+
+	return !strings.ContainsFunc(e, unicode.IsSpace)
+}
+
+// react adds or takes back the reaction of sess to a stored message and
+// tells its room the new counts.
+func (s *Server) react(sess *session, env *protocol.Envelope) {
+	if !validEmoji(env.Emoji) {
+		sess.send(protocol.NewError("reactions must be a single emoji"))
+		return
+	}
+
+	s.editsMu.Lock()
+	defer s.editsMu.Unlock()
+
+	msg, err := s.storedChat(sess, env)
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	reactions, err := toggleReaction(msg.Reactions, env.Emoji, sess.name, env.Active)
+	if err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+	if reactions == nil && msg.Reactions == nil {
+		return
+	}
+
+	reacted := *msg
+	reacted.Reactions = reactions
+	if err := s.store.Update(&reacted); err != nil {
+		sess.send(protocol.NewError(err.Error()))
+		return
+	}
+
+	frame := protocol.NewReact(msg.Room, msg.ID, env.Emoji, env.Active)
+	frame.Sender = sess.name
+	frame.Reactions = reactions
+	s.relay(nil, frame)
+}
+
+// toggleReaction returns a copy of reactions with the reaction of user with
+// emoji added or removed. Emoji nobody reacted with anymore are dropped, nil
+// is returned when none are left.
+func toggleReaction(reactions []protocol.Reaction, emoji, user string, active bool) ([]protocol.Reaction, error) {
+	var out []protocol.Reaction
+	found := false
+	for _, r := range reactions {
+		if r.Emoji != emoji {
+			out = append(out, r)
+			continue
+		}
+		found = true
+
+		users := slices.DeleteFunc(slices.Clone(r.Users), func(u string) bool { return strings.EqualFold(u, user) })
+		if active {
+			users = append(users, user)
+		}
+		if len(users) > 0 {
+			out = append(out, protocol.Reaction{Emoji: emoji, Count: len(users), Users: users})
+		}
+	}
+
+	if !found && active {
+		if len(reactions) >= maxReactions {
+			return nil, fmt.Errorf("a message can have at most %d different reactions", maxReactions)
+		}
+		out = append(out, protocol.Reaction{Emoji: emoji, Count: 1, Users: []string{user}})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+func TestToggleReaction(t *testing.T) {
+	thumbs := protocol.Reaction{Emoji: "👍", Count: 2, Users: []string{"alice", "bob"}}
+	party := protocol.Reaction{Emoji: "🎉", Count: 1, Users: []string{"carol"}}
+
+	var full []protocol.Reaction
+	for i := range maxReactions {
+		full = append(full, protocol.Reaction{Emoji: fmt.Sprint(i), Count: 1, Users: []string{"alice"}})
+	}
+
+	tests := []struct {
+		name      string
+		reactions []protocol.Reaction
+		emoji     string
+		user      string
+		active    bool
+		want      []protocol.Reaction
+		err       bool
+	}{
+		{"first", nil, "👍", "alice", true, []protocol.Reaction{{Emoji: "👍", Count: 1, Users: []string{"alice"}}}, false},
+		{"join others", []protocol.Reaction{party}, "🎉", "dave", true, []protocol.Reaction{{Emoji: "🎉", Count: 2, Users: []string{"carol", "dave"}}}, false},
+		{"new emoji last", []protocol.Reaction{thumbs}, "🎉", "carol", true, []protocol.Reaction{thumbs, party}, false},
+		{"twice counts once", []protocol.Reaction{thumbs}, "👍", "ALICE", true, []protocol.Reaction{{Emoji: "👍", Count: 2, Users: []string{"bob", "ALICE"}}}, false},
+		{"take back", []protocol.Reaction{thumbs, party}, "👍", "Bob", false, []protocol.Reaction{{Emoji: "👍", Count: 1, Users: []string{"alice"}}, party}, false},
+		{"last user drops emoji", []protocol.Reaction{thumbs, party}, "🎉", "carol", false, []protocol.Reaction{thumbs}, false},
+		{"none left", []protocol.Reaction{party}, "🎉", "carol", false, nil, false},
+		{"take back unknown", []protocol.Reaction{thumbs}, "🎉", "alice", false, []protocol.Reaction{thumbs}, false},
+		{"too many", full, "👍", "bob", true, nil, true},
+		{"existing at limit", full, "0", "bob", true, append([]protocol.Reaction{{Emoji: "0", Count: 2, Users: []string{"alice", "bob"}}}, full[1:]...), false},
+	}
+
+	for _, tt := range tests {
+		before := fmt.Sprint(tt.reactions)
+
+		got, err := toggleReaction(tt.reactions, tt.emoji, tt.user, tt.active)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if after := fmt.Sprint(tt.reactions); after != before {
+			t.Errorf("%s: reactions changed to %v", tt.name, after)
+		}
+	}
+}
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👩‍👩‍👧‍👦", true},
+		{"", false},
+		{"a", false},
+		{":)", false},
+		{"👍 👍", false},
+		{"\xff", false},
+		{strings.Repeat("👍", maxEmojiSize/4+1), false},
+	}
+
+	for _, tt := range tests {
+		if got := validEmoji(tt.emoji); got != tt.want {
+			t.Errorf("validEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
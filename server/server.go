@@ -1,295 +1,492 @@
-package main
+// Package server implements the Go Chat TCP server and the LiveKit token
+// endpoint used for voice chat. A Server can be embedded in any binary, and
+// several can run side by side in one process as long as their listen
+// addresses differ.
+package server
 
 import (
-	"bufio"
 	"context"
-	_ "embed"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
-	"os"
-	"strings"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
 	"github.com/go-chi/chi"
-	"github.com/joho/godotenv"
-	"github.com/livekit/protocol/auth"
 	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
-//go:embed .env
-var embeddedEnv string
-
-var (
-	conns = &sync.Map{}
-	names = &sync.Map{}
-)
-
-var chatContext []openai.ChatCompletionMessageParamUnion
+// DefaultMaxMessageSize is the message body limit when Config leaves it unset.
+const DefaultMaxMessageSize = 4096
+
+// maxMessageLimit leaves room in a frame for the rest of the envelope.
+const maxMessageLimit = protocol.MaxFrameSize - 1024
+
+// Config holds everything a Server needs to run.
+type Config struct {
+	// TCPAddr is the chat listen address, e.g. ":8000"
+	TCPAddr string
+	// TokenAddr is the HTTP listen address for the /token and /metrics
+	// endpoints, empty disables it
+	TokenAddr string
+	// RoomName is the LiveKit room backing the default channel, other
+	// channels use RoomName + "_" + channel
+	RoomName string
+
+	// TLSCertFile and TLSKeyFile enable TLS on both listeners. With
+	// TLSSelfSigned set, a self-signed certificate is generated and written
+	// to them when they don't exist yet, or kept in memory if they are empty.
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSelfSigned bool
+
+	// Store persists chat messages, a MemoryStore is used when nil. The
+	// Server closes it on Shutdown.
+	Store Store
+	// HistorySize is how many messages are replayed when joining a channel
+	// and the page size for scroll-back requests
+	HistorySize int
+
+	// Accounts holds registered users, a MemoryAccounts is used when nil
+	Accounts AccountStore
+	// ReservedNames can't be registered, on top of the built in "Server" and "AI"
+	ReservedNames []string
+	// Admins are the accounts allowed to run PermAdmin commands
+	Admins []string
+	// Moderators may edit and delete anyone's messages, as may Admins
+	Moderators []string
+
+	// OpenAIKey enables the bots when set. AIBaseURL points them at an
+	// OpenAI compatible endpoint instead of api.openai.com, which enables
+	// them without a key as well.
+	OpenAIKey string
+	AIBaseURL string
+	// AIProvider answers the bots' prompts instead of the OpenAI API
+	AIProvider Provider
+	// Personas are the bots, a single "AI" run by #chat when empty
+	Personas []Persona
+	// AIModel and AISystemPrompt are the defaults of every persona
+	AIModel        string
+	AISystemPrompt string
+	// AIMaxTurns is how many prompts and answers the bot remembers per
+	// room, AIMaxTokens roughly how many tokens of context it may send. The
+	// oldest exchanges are forgotten first.
+	AIMaxTurns  int
+	AIMaxTokens int
+	// AITimeout is how long a bot may take to answer once it is a prompt's
+	// turn, prompts of one room are answered one at a time
+	AITimeout time.Duration
+	// AIUserRate and AIGlobalRate are how many prompts one user and everyone
+	// together may send the bots per minute. AIUserDailyTokens and
+	// AIDailyTokens roughly cap the tokens they may use per day. Zero means
+	// no limit.
+	AIUserRate        int
+	AIGlobalRate      int
+	AIUserDailyTokens int
+	AIDailyTokens     int
+
+	// SendQueueSize is how many frames may wait for a slow client before
+	// SlowConsumer applies, WriteTimeout how long a single write may take
+	// before the connection is considered dead
+	SendQueueSize int
+	WriteTimeout  time.Duration
+	SlowConsumer  SlowConsumerPolicy
+
+	// MaxMessageSize is the largest message body in bytes a client may send,
+	// capped so the message still fits in a protocol frame. Longer messages
+	// are answered with an error and not delivered.
+	MaxMessageSize int
+
+	LiveKitURL       string
+	LiveKitAPIKey    string
+	LiveKitAPISecret string
+
+	Hooks Hooks
+}
 
-var api_key string
+// Hooks are optional callbacks invoked from connection goroutines. They must
+// not block.
+type Hooks struct {
+	// OnConnect runs after a client completed the handshake
+	OnConnect func(displayName, remoteAddr string)
+	// OnDisconnect runs after a client connection is closed
+	OnDisconnect func(displayName, remoteAddr string)
+	// OnMessage runs for every chat message received from a client
+	OnMessage func(msg *protocol.Envelope)
+}
 
-func chat(api_key string) (rsp string) {
-	client := openai.NewClient(
-		option.WithAPIKey(api_key),
-	)
-	ctx := context.Background()
+// DefaultConfig returns the ports and room name the client expects.
+func DefaultConfig() Config {
+	return Config{
+		TCPAddr:        ":8000",
+		TokenAddr:      "0.0.0.0:8080",
+		RoomName:       "GO_CHAT",
+		HistorySize:    50,
+		SendQueueSize:  256,
+		WriteTimeout:   10 * time.Second,
+		SlowConsumer:   SlowConsumerDisconnect,
+		MaxMessageSize: DefaultMaxMessageSize,
+		AIModel:        openai.ChatModelGPT4_1Mini,
+		AIMaxTurns:     20,
+		AIMaxTokens:    4000,
+		AITimeout:      60 * time.Second,
+		AIUserRate:     5,
+		AIGlobalRate:   30,
+		AISystemPrompt: "you are a gen z kid in a groupchat. use gen z slang and typeface.",
+
+		AIUserDailyTokens: 50000,
+		AIDailyTokens:     500000,
+	}
+}
 
-	// Needed to include instruction in the system message to not include newlines in the reponse to prevent trimming of the rendered message in chat ui
-	chatContext = append(chatContext, openai.SystemMessage("you are a gen z kid in a groupchat. use gen z slang and typeface. DO NOT USE NEWLINES IN YOUR RESPONSE."))
+type Server struct {
+	cfg Config
+
+	// conns maps remote address to *session
+	conns *sync.Map
+	// accepted holds every open net.Conn, handshake done or not, so Shutdown
+	// can cut off those that never logged in too
+	accepted sync.Map
+
+	mu       sync.Mutex
+	channels map[string]*channel
+
+	// nextID hands out the ids of stored messages, continuing after the
+	// last one. Frames that aren't stored get none, so no id is handed out
+	// twice across restarts.
+	nextID atomic.Uint64
+	// order is held from stamping a message id until the message is queued
+	// for every recipient, so all clients see messages in id order
+	order sync.Mutex
+
+	store Store
+
+	accounts AccountStore
+	// accountsMu serializes read-modify-write cycles on accounts
+	accountsMu sync.Mutex
+
+	// editsMu serializes edits, deletes and reactions of stored messages, so
+	// none undoes another
+	editsMu sync.Mutex
+
+	// commands maps lowercase names to the registered #commands
+	commands   map[string]*Command
+	commandsMu sync.RWMutex
+
+	// provider answers the personas, nil when no bot is set up
+	provider Provider
+	// aiRooms holds what each persona remembers of each room, keyed by room
+	// and lowercase persona name, guarded by aiMu
+	aiRooms map[string]map[string]*aiMemory
+	// aiQueues holds the prompts being answered or waiting per room, guarded
+	// by aiMu
+	aiQueues map[string]*aiQueue
+	aiMu     sync.Mutex
+	// aiCtx is cancelled by Shutdown, aiRunning waits for the answers to end
+	aiCtx     context.Context
+	aiCancel  context.CancelFunc
+	aiRunning sync.WaitGroup
+	// limiter enforces the bots' rate limits and token budgets
+	limiter *aiLimiter
+
+	listener   net.Listener
+	httpServer *http.Server
+	handlers   sync.WaitGroup
+	// closing is set once Shutdown started, late handshakes are turned away
+	closing atomic.Bool
+
+	// dropped and slowDisconnects count slow consumer events for Stats
+	dropped         atomic.Uint64
+	slowDisconnects atomic.Uint64
+}
 
-	completion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: chatContext,
-		Seed:     openai.Int(0),
-		Model:    openai.ChatModelGPT4_1Mini,
+func New(cfg Config) *Server {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore(1000)
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = 50
+	}
+	if cfg.Accounts == nil {
+		cfg.Accounts = NewMemoryAccounts()
+	}
+	if cfg.SendQueueSize <= 0 {
+		cfg.SendQueueSize = 256
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.SlowConsumer == "" {
+		cfg.SlowConsumer = SlowConsumerDisconnect
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultMaxMessageSize
+	}
+	cfg.MaxMessageSize = min(cfg.MaxMessageSize, maxMessageLimit)
+	if cfg.AIModel == "" {
+		cfg.AIModel = openai.ChatModelGPT4_1Mini
+	}
+	if cfg.AIMaxTurns <= 0 {
+		cfg.AIMaxTurns = 20
+	}
+	if cfg.AIMaxTokens <= 0 {
+		cfg.AIMaxTokens = 4000
+	}
+	if cfg.AITimeout <= 0 {
+		cfg.AITimeout = 60 * time.Second
+	}
+	if len(cfg.Personas) == 0 {
+		cfg.Personas = []Persona{{Name: protocol.SenderAI, Command: "chat"}}
+	}
+	cfg.Personas = slices.DeleteFunc(slices.Clone(cfg.Personas), func(p Persona) bool {
+		if err := personaNameError(cfg, p.Name); err != nil {
+			fmt.Printf("persona %s skipped: %v\n", p.Name, err)
+			return true
+		}
+		return false
 	})
-
-	if err != nil {
-		fmt.Println("Error sending request to OpenAI API: ", err)
-		return ""
+	for i := range cfg.Personas {
+		p := &cfg.Personas[i]
+		if p.SystemPrompt == "" {
+			p.SystemPrompt = cfg.AISystemPrompt
+		}
+		if p.Model == "" {
+			p.Model = cfg.AIModel
+		}
 	}
 
-	rsp = completion.Choices[0].Message.Content
+	s := &Server{
+		cfg:   cfg,
+		conns: &sync.Map{},
+		channels: map[string]*channel{
+			protocol.DefaultChannel: {name: protocol.DefaultChannel, members: map[*session]struct{}{}},
+		},
+		store:    cfg.Store,
+		accounts: cfg.Accounts,
+		commands: map[string]*Command{},
+		provider: cfg.AIProvider,
+		aiRooms:  map[string]map[string]*aiMemory{},
+		aiQueues: map[string]*aiQueue{},
+		limiter:  newAILimiter(cfg),
+	}
+	s.aiCtx, s.aiCancel = context.WithCancel(context.Background())
+	if s.provider == nil && (cfg.OpenAIKey != "" || cfg.AIBaseURL != "") {
+		s.provider = NewOpenAIProvider(cfg.OpenAIKey, cfg.AIBaseURL)
+	}
+	s.nextID.Store(cfg.Store.LastID())
 
-	fmt.Println("CHAT RESPONSE: ", rsp)
+	for _, cmd := range s.builtinCommands() {
+		if err := s.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+	s.registerPersonas()
 
-	return rsp
+	return s
 }
 
-func handleConnections(conn net.Conn) {
-	// Read and store connected user display name
-	id := fmt.Sprintf("%p", conn)
-	rd := bufio.NewReader(conn)
+// Start binds the chat listener and, if configured, the token endpoint, then
+// serves both in the background. It returns once both are listening.
+func (s *Server) Start(ctx context.Context) error {
+	var lc net.ListenConfig
 
-	name_line, err := rd.ReadString('\n')
+	tlsConfig, err := s.tlsConfig()
 	if err != nil {
-		fmt.Printf("error reading display name: %q\n", err)
-		return
+		return err
 	}
 
-	display_name := strings.TrimSpace(name_line)
-
-	defer func() {
-		conn.Close()
-		conns.Delete(conn.RemoteAddr().String())
-		names.Delete(id)
-		broadcastMsg(nil, conns, fmt.Sprintf("<%s left the room>\n", display_name))
-		fmt.Printf("\n%s | %s left the room\n", display_name, conn.RemoteAddr().String())
-	}()
-
-	names.Store(id, display_name)
-	conns.Store(conn.RemoteAddr().String(), conn)
-	fmt.Printf("\nNew Connection: %s | %s\n\n", display_name, conn.RemoteAddr().String())
-	broadcastMsg(conn, conns, fmt.Sprintf("<%s joined the room>\n", display_name))
+	listener, err := lc.Listen(ctx, "tcp", s.cfg.TCPAddr)
+	if err != nil {
+		return fmt.Errorf("error starting tcp listener: %w", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.listener = listener
 
-	buffer := make([]byte, 1024)
+	fmt.Printf("\nTCP Server listening on %s...\n", listener.Addr().String())
 
-	for {
-		n, err := conn.Read(buffer)
+	if s.cfg.TokenAddr != "" {
+		tokenListener, err := lc.Listen(ctx, "tcp", s.cfg.TokenAddr)
 		if err != nil {
-			fmt.Println(err)
-			break
+			listener.Close()
+			return fmt.Errorf("error starting token listener: %w", err)
+		}
+		if tlsConfig != nil {
+			tokenListener = tls.NewListener(tokenListener, tlsConfig)
 		}
 
-		fmt.Printf("%s | %s\n", strings.Trim(string(buffer[:n]), "\n"), conn.RemoteAddr().String())
-		broadcastMsg(conn, conns, string(buffer[:n]))
-
-		//TODO find out why this fixes the issue where only the sender of the room command receives the result
-		time.Sleep(1 * time.Second)
-
-		//Find command in user message, server sends message
-		t, command := findCommand(strings.Trim(string(buffer[:n]), "\n"))
-
-		//debug
-		fmt.Printf("<findCommand : %t | %s>\n", t, command)
-
-		if t {
-			switch command {
-			case "room":
-				go func() {
-					var list []string
-					names.Range(func(_, value any) bool {
-						list = append(list, value.(string))
-						return true
-					})
-					users := strings.Join(list, ", ")
-					command_return := fmt.Sprintf("Connected Users %v\n", "["+users+"]")
-					broadcastMsg(nil, conns, command_return)
-				}()
-			case "chat":
-				go func() {
-					if api_key != "" {
-						b, prompt := findPrompt(strings.Trim(string(buffer[:n]), "\n"))
-						prompt = display_name + ": " + prompt
-						if b {
-							chatContext = append(chatContext, openai.UserMessage(prompt))
-							//fmt.Println("Sending prompt: ", prompt)
-							raw_rsp := chat(api_key)
-							rsp := fmt.Sprintf("AI: %s\n", raw_rsp)
-							chatContext = append(chatContext, openai.AssistantMessage(raw_rsp))
-							broadcastMsg(nil, conns, rsp)
-						}
-					} else {
-						fmt.Println("<No API Key Found>")
-						broadcastMsg(nil, conns, "<No API Key Found>\n")
-					}
-				}()
-			default:
-				continue
+		r := chi.NewRouter()
+		r.Get("/token", s.tokenHandler())
+		r.Get("/metrics", s.metricsHandler())
+		s.httpServer = &http.Server{Handler: r}
+
+		fmt.Printf("/token endpoint started on %s\n", tokenListener.Addr().String())
+
+		go func() {
+			if err := s.httpServer.Serve(tokenListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Println(err)
 			}
-		}
+		}()
 	}
-}
-
-func broadcastMsg(sender net.Conn, conMap *sync.Map, msg string) {
-	conMap.Range(func(key any, value any) bool {
-		client := value.(net.Conn)
 
-		if sender == client {
-			return true
-		}
+	go s.acceptLoop()
 
-		_, err := client.Write([]byte(msg))
-		if err != nil {
-			fmt.Println(err)
-		}
-		return true
-	})
+	return nil
 }
 
-// pretty ugly, need to change this and use regex
-func findCommand(msg string) (bool, string) {
-	cmd_index := strings.Index(msg, "#")
-
-	if cmd_index != -1 {
-		space_index := strings.Index(msg[cmd_index+1:], " ")
+// Addr returns the address the chat listener is bound to, useful when
+// TCPAddr asks for a random port.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
 
-		if space_index != -1 {
-			return true, msg[cmd_index+1:][:space_index]
-		}
+// Shutdown stops accepting connections, tells every connected client the
+// server is going away and gives them until ctx expires to disconnect before
+// their connections are closed. The token endpoint is drained with the same
+// deadline and the store is closed last, so no message is lost.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	// Bots stop writing, what they have so far is sent and stored
+	s.aiCancel()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
 
-	} else {
-		return false, ""
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
 	}
 
-	return true, msg[cmd_index+1:]
+	s.mu.Lock()
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
 
-}
+	for _, name := range names {
+		s.broadcastMsg(nil, protocol.NewNotice(name, "<Server is shutting down>"))
+	}
 
-func findPrompt(msg string) (bool, string) {
-	open_quote := strings.Index(msg, `"`)
+	s.conns.Range(func(_, value any) bool {
+		value.(*session).goodbye(protocol.ReasonShutdown, "Server shut down")
+		return true
+	})
 
-	if open_quote != -1 {
-		close_quote := strings.Index(msg[open_quote+1:], `"`)
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
 
-		if close_quote != -1 {
-			return true, msg[open_quote+1:][:close_quote]
-		} else {
-			return false, ""
-		}
-	} else {
-		return false, ""
-	}
-}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
 
-func getJoinToken(apiKey, apiSecret, room, identity string) (string, error) {
-	at := auth.NewAccessToken(apiKey, apiSecret)
-	grant := &auth.VideoGrant{
-		RoomJoin: true,
-		Room:     room,
+		// Clients that did not hang up in time are cut off, their handlers
+		// return as soon as the read fails
+		s.accepted.Range(func(key, _ any) bool {
+			key.(net.Conn).Close()
+			return true
+		})
+		<-done
 	}
-	at.SetVideoGrant(grant).
-		SetIdentity(identity).
-		SetValidFor(time.Hour)
 
-	return at.ToJWT()
-}
+	s.aiRunning.Wait()
 
-func startTCP() {
-	listener, err := net.Listen("tcp", ":8000")
-	if err != nil {
-		fmt.Println(err)
+	if storeErr := s.store.Close(); err == nil {
+		err = storeErr
 	}
 
-	fmt.Println("\nTCP Server listening on Port 8000...")
+	return err
+}
 
+func (s *Server) acceptLoop() {
 	for {
-		conn, err := listener.Accept()
+		conn, err := s.listener.Accept()
 		if err != nil {
-			fmt.Println(err)
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Println(err)
+			}
 			break
 		}
 
-		go handleConnections(conn)
+		s.handlers.Add(1)
+		s.accepted.Store(conn, struct{}{})
+		go func() {
+			defer s.handlers.Done()
+			defer s.accepted.Delete(conn)
+			s.handleConnections(conn)
+		}()
 	}
 }
 
-func tokenHandler() http.HandlerFunc {
-	type tokenResponse struct {
-		JWTToken string `json:"jwtToken"`
-		HostURL  string `json:"hostUrl"`
-	}
+// relay sends env as it is to everyone in its room except sender. Unlike
+// broadcastMsg it neither stamps nor stores it, for edits of earlier messages
+// and frames about what is going on in the room.
+func (s *Server) relay(sender *session, env *protocol.Envelope) {
+	s.order.Lock()
+	defer s.order.Unlock()
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		hostURL := os.Getenv("LIVEKIT_URL")
-		apiKey := os.Getenv("LIVEKIT_API_KEY")
-		apiSecret := os.Getenv("LIVEKIT_API_SECRET")
-
-		displayName := r.URL.Query().Get("name")
-
-		if displayName == "" {
-			http.Error(w, "'name' parameter required", http.StatusBadRequest)
-			return
-		}
+	frame, err := protocol.Encode(env)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-		joinToken, err := getJoinToken(apiKey, apiSecret, "GO_CHAT", displayName)
-		if err != nil {
-			log.Fatalf("failed to create join token: %q", err)
+	for _, member := range s.channelMembers(env.Room) {
+		if sender == member {
+			continue
 		}
+		member.sendFrame(frame)
+	}
+}
 
-		resp := tokenResponse{
-			JWTToken: joinToken,
-			HostURL:  hostURL,
+// broadcastMsg stamps msg with the next message id, stores it if it is a
+// chat message and queues it for every member of msg.Room except sender
+func (s *Server) broadcastMsg(sender *session, msg *protocol.Envelope) {
+	s.order.Lock()
+	defer s.order.Unlock()
+
+	if msg.Type == protocol.TypeChat {
+		msg.ID = s.nextID.Add(1)
+
+		// Quotes are built again whenever a reply is sent from the store, so
+		// they follow edits and deletes of the message answered
+		stored := msg
+		if msg.Quote != nil {
+			unquoted := *msg
+			unquoted.Quote = nil
+			stored = &unquoted
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			log.Printf("failed to write JSON: %v\n", err)
+		if err := s.store.Append(stored); err != nil {
+			fmt.Println(err)
 		}
 	}
-}
-
-func startTokenServer() {
-	//client := &http.Client{}
-	r := chi.NewRouter()
-	r.Get("/token", tokenHandler())
 
-	fmt.Println("/token endpoint started on Port 8080")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080", r))
-}
-
-func main() {
-	//godotenv.Load(".env")
-	envMap, err := godotenv.Unmarshal(embeddedEnv)
+	frame, err := protocol.Encode(msg)
 	if err != nil {
-		fmt.Printf("Cannot parse embedded .env: %v\n", err)
-		os.Exit(1)
-	}
-	for key, val := range envMap {
-		os.Setenv(key, val)
+		fmt.Println(err)
+		return
 	}
 
-	api_key = os.Getenv("OPENAI_API_KEY")
-
-	go startTCP()
-	go startTokenServer()
-
-	select {}
+	for _, member := range s.channelMembers(msg.Room) {
+		if sender == member {
+			continue
+		}
 
+		// Queue errors are handled by the member's writer, the sender never waits
+		member.sendFrame(frame)
+	}
 }
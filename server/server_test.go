@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+const testPassword = "password123"
+
+// startServer runs a server on cfg.TCPAddr, a random local port if empty,
+// until the test ends.
+func startServer(t *testing.T, cfg server.Config) *server.Server {
+	t.Helper()
+
+	if cfg.TCPAddr == "" {
+		cfg.TCPAddr = "127.0.0.1:0"
+	}
+	s := server.New(cfg)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	return s
+}
+
+// dial logs name in with the headless client, registering the account first
+// if register is set.
+func dial(t *testing.T, s *server.Server, name string, register, reconnect bool) *client.Client {
+	t.Helper()
+
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	c := client.New(client.Config{
+		DisplayName:   name,
+		ServerAddress: "127.0.0.1",
+		ChatPort:      port,
+		Password:      testPassword,
+		Register:      register,
+		Reconnect:     reconnect,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+// waitFor returns the first message matching ok, failing the test if none
+// arrives in time.
+func waitFor(t *testing.T, c *client.Client, ok func(*protocol.Envelope) bool) *protocol.Envelope {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case env, open := <-c.Messages():
+			if !open {
+				t.Fatalf("%s: connection closed", c.DisplayName())
+			}
+			if ok(env) {
+				return env
+			}
+		case <-timeout:
+			t.Fatalf("%s: timed out", c.DisplayName())
+		}
+	}
+}
+
+// chatWith matches a chat message with body, live or replayed.
+func chatWith(body string) func(*protocol.Envelope) bool {
+	return func(env *protocol.Envelope) bool {
+		if env.Type == protocol.TypeChat && env.Body == body {
+			return true
+		}
+		for _, m := range env.History {
+			if m.Body == body {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Messages sent while a client is away must be replayed when it resumes,
+// even when the server restarted in between.
+func TestResumeAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+	accounts := server.NewMemoryAccounts()
+
+	store, err := server.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := server.New(server.Config{TCPAddr: "127.0.0.1:0", Store: store, Accounts: accounts})
+	if err := first.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	addr := first.Addr().String()
+
+	alice := dial(t, first, "alice", true, true)
+	bob := dial(t, first, "bob", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	bob.Send(protocol.DefaultChannel, "before restart")
+	waitFor(t, alice, chatWith("before restart"))
+
+	// Direct messages and notices are never stored, whatever Alice saw of
+	// them must not make her skip stored messages
+	for range 3 {
+		bob.SendDirect("alice", "psst")
+		waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeDirect })
+	}
+
+	if err := first.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	bob.Close()
+
+	store, err = server.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := startServer(t, server.Config{TCPAddr: addr, Store: store, Accounts: accounts})
+
+	// Bob is back before Alice's client redials
+	bob = dial(t, second, "bob", false, false)
+	bob.Send(protocol.DefaultChannel, "after restart")
+
+	waitFor(t, alice, chatWith("after restart"))
+}
+
+// A connection that never sends its hello can't hold up Shutdown past its
+// deadline.
+func TestShutdownWithoutHello(t *testing.T) {
+	s := server.New(server.Config{TCPAddr: "127.0.0.1:0"})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Give the server time to accept it
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s with a 500ms deadline", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("connection still open after Shutdown: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// SlowConsumerPolicy decides what happens when a client doesn't read fast
+// enough and its outbound queue fills up.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect closes the connection, a reconnecting client
+	// resumes and gets the stored messages it missed
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// SlowConsumerDropOldest discards the oldest queued frame to make room
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop-oldest"
+)
+
+var (
+	errSessionClosed = errors.New("session closed")
+	errSlowConsumer  = errors.New("client too slow, disconnecting")
+)
+
+// session is a connected client that completed the handshake. Frames for it
+// are queued and written by its own writer goroutine, so a stalled client
+// never blocks the sender.
+type session struct {
+	conn net.Conn
+	name string
+
+	// channels the session is a member of, guarded by Server.mu
+	channels map[string]struct{}
+	// status and voice, the channel whose voice room the client is in, are
+	// its presence, guarded by Server.mu
+	status protocol.Status
+	voice  string
+
+	out          chan []byte
+	policy       SlowConsumerPolicy
+	writeTimeout time.Duration
+
+	// kicked asks the writer to drop everything and disconnect, flush to
+	// write what is queued and half close, done to stop right away
+	kicked    chan struct{}
+	flush     chan struct{}
+	done      chan struct{}
+	kickOnce  sync.Once
+	flushOnce sync.Once
+	doneOnce  sync.Once
+	// finished is closed when the writer returned
+	finished chan struct{}
+
+	highWater atomic.Int64
+	dropped   atomic.Uint64
+	// onDrop and onKick feed the server wide counters
+	onDrop func()
+	onKick func()
+}
+
+func newSession(conn net.Conn, name string, queueSize int, policy SlowConsumerPolicy, writeTimeout time.Duration) *session {
+	return &session{
+		conn:         conn,
+		name:         name,
+		channels:     map[string]struct{}{},
+		status:       protocol.StatusOnline,
+		out:          make(chan []byte, queueSize),
+		policy:       policy,
+		writeTimeout: writeTimeout,
+		kicked:       make(chan struct{}),
+		flush:        make(chan struct{}),
+		done:         make(chan struct{}),
+		finished:     make(chan struct{}),
+		onDrop:       func() {},
+		onKick:       func() {},
+	}
+}
+
+// send queues a single frame for the session.
+func (sess *session) send(env *protocol.Envelope) error {
+	frame, err := protocol.Encode(env)
+	if err != nil {
+		return err
+	}
+
+	return sess.sendFrame(frame)
+}
+
+// sendFrame queues an already encoded frame, used to fan one message out to
+// many sessions without encoding it for each.
+func (sess *session) sendFrame(frame []byte) error {
+	return sess.enqueue(frame, sess.policy)
+}
+
+// enqueue adds frame to the outbound queue without blocking. A full queue is
+// handled according to policy.
+func (sess *session) enqueue(frame []byte, policy SlowConsumerPolicy) error {
+	select {
+	case <-sess.finished:
+		return errSessionClosed
+	default:
+	}
+
+	select {
+	case sess.out <- frame:
+		sess.noteDepth()
+		return nil
+	default:
+	}
+
+	if policy != SlowConsumerDropOldest {
+		sess.kick()
+		return errSlowConsumer
+	}
+
+	for {
+		select {
+		case <-sess.out:
+			sess.dropped.Add(1)
+			sess.onDrop()
+		default:
+		}
+
+		select {
+		case sess.out <- frame:
+			sess.noteDepth()
+			return nil
+		default:
+		}
+	}
+}
+
+func (sess *session) noteDepth() {
+	depth := int64(len(sess.out))
+	for {
+		hw := sess.highWater.Load()
+		if depth <= hw || sess.highWater.CompareAndSwap(hw, depth) {
+			return
+		}
+	}
+}
+
+// writeLoop writes queued frames until the session ends. A failed or timed
+// out write closes the connection, which ends the handler as well.
+func (sess *session) writeLoop() {
+	defer close(sess.finished)
+
+	for {
+		select {
+		case frame := <-sess.out:
+			if err := sess.write(frame); err != nil {
+				fmt.Printf("error writing to %s: %v\n", sess.name, err)
+				sess.conn.Close()
+				return
+			}
+
+		case <-sess.flush:
+			for {
+				select {
+				case frame := <-sess.out:
+					if err := sess.write(frame); err != nil {
+						sess.conn.Close()
+						return
+					}
+					continue
+				default:
+				}
+				break
+			}
+
+			if cw, ok := sess.conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			} else {
+				sess.conn.Close()
+			}
+			return
+
+		case <-sess.kicked:
+			fmt.Printf("<%s | %s can't keep up, disconnecting>\n", sess.name, sess.conn.RemoteAddr().String())
+			if frame, err := protocol.Encode(protocol.NewGoodbye(protocol.ReasonSlowConsumer, "Disconnected for falling behind")); err == nil {
+				sess.write(frame)
+			}
+			sess.conn.Close()
+			return
+
+		case <-sess.done:
+			return
+		}
+	}
+}
+
+func (sess *session) write(frame []byte) error {
+	sess.conn.SetWriteDeadline(time.Now().Add(sess.writeTimeout))
+	_, err := sess.conn.Write(frame)
+	return err
+}
+
+// kick disconnects a client that fell behind.
+func (sess *session) kick() {
+	sess.kickOnce.Do(func() {
+		sess.onKick()
+		close(sess.kicked)
+	})
+}
+
+// goodbye queues the last frame of the session and closes the writing half
+// of the connection once everything queued is written, so the client reads
+// all of it before it sees the end of the stream. The handler returns once
+// the client hangs up.
+func (sess *session) goodbye(reason protocol.Reason, body string) {
+	frame, err := protocol.Encode(protocol.NewGoodbye(reason, body))
+	if err == nil {
+		// The goodbye must get through even to a client that is behind
+		err = sess.enqueue(frame, SlowConsumerDropOldest)
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	sess.flushOnce.Do(func() { close(sess.flush) })
+}
+
+// stop ends the writer without writing anything still queued.
+func (sess *session) stop() {
+	sess.doneOnce.Do(func() { close(sess.done) })
+}
@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// A silent command is seen by nobody but its caller, the reply included.
+func TestSilentCommand(t *testing.T) {
+	s := startServer(t, server.Config{})
+	err := s.Register(server.Command{
+		Name:   "secret",
+		Args:   []server.Arg{{Name: "text", Rest: true}},
+		Silent: true,
+		Run: func(ctx *server.CommandContext) error {
+			ctx.Respond("kept " + ctx.Arg("text"))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	alice.Send(protocol.DefaultChannel, "#secret hunter2")
+	reply := waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice && env.Ephemeral })
+	if reply.Body != "kept hunter2" {
+		t.Errorf("reply = %q, want kept hunter2", reply.Body)
+	}
+
+	// Frames arrive in order, the command or its reply would come before
+	// this
+	alice.Send(protocol.DefaultChannel, "done")
+	secret := func(env *protocol.Envelope) bool { return strings.Contains(env.Body, "hunter2") }
+	if env := waitFor(t, bob, func(env *protocol.Envelope) bool { return secret(env) || chatWith("done")(env) }); secret(env) {
+		t.Errorf("bob got %s %q", env.Type, env.Body)
+	}
+
+	if err := bob.RequestHistory(protocol.DefaultChannel, 0); err != nil {
+		t.Fatal(err)
+	}
+	history := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeHistory })
+	if len(history.History) == 0 {
+		t.Fatal("history is empty, want at least done")
+	}
+	for _, m := range history.History {
+		if secret(m) {
+			t.Errorf("history has %s %q", m.Type, m.Body)
+		}
+	}
+}
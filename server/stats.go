@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Stats is a snapshot of the outbound queues of all connected clients.
+type Stats struct {
+	Connections int
+	// Queued is the number of frames waiting across all connections,
+	// MaxQueued the deepest single queue right now
+	Queued    int
+	MaxQueued int
+	// Dropped counts frames discarded under SlowConsumerDropOldest,
+	// SlowDisconnects clients cut off under SlowConsumerDisconnect
+	Dropped         uint64
+	SlowDisconnects uint64
+
+	Sessions []SessionStats
+}
+
+// SessionStats describes the outbound queue of one connection.
+type SessionStats struct {
+	Name       string
+	RemoteAddr string
+	Queued     int
+	// HighWater is the deepest the queue has been
+	HighWater int
+	Dropped   uint64
+}
+
+// Stats returns the current queue depths and slow consumer counters.
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Dropped:         s.dropped.Load(),
+		SlowDisconnects: s.slowDisconnects.Load(),
+	}
+
+	s.conns.Range(func(_, value any) bool {
+		sess := value.(*session)
+		ss := SessionStats{
+			Name:       sess.name,
+			RemoteAddr: sess.conn.RemoteAddr().String(),
+			Queued:     len(sess.out),
+			HighWater:  int(sess.highWater.Load()),
+			Dropped:    sess.dropped.Load(),
+		}
+
+		stats.Connections++
+		stats.Queued += ss.Queued
+		stats.MaxQueued = max(stats.MaxQueued, ss.Queued)
+		stats.Sessions = append(stats.Sessions, ss)
+		return true
+	})
+
+	sort.Slice(stats.Sessions, func(i, j int) bool {
+		return stats.Sessions[i].RemoteAddr < stats.Sessions[j].RemoteAddr
+	})
+
+	return stats
+}
+
+// metricsHandler serves the aggregate Stats in the Prometheus text format.
+// Per connection figures are left out since they would expose who is online.
+func (s *Server) metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := s.Stats()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# HELP gochat_connections Connected clients.\n# TYPE gochat_connections gauge\ngochat_connections %d\n", stats.Connections)
+		fmt.Fprintf(w, "# HELP gochat_send_queue_frames Frames waiting in outbound queues.\n# TYPE gochat_send_queue_frames gauge\ngochat_send_queue_frames %d\n", stats.Queued)
+		fmt.Fprintf(w, "# HELP gochat_send_queue_max_frames Deepest outbound queue.\n# TYPE gochat_send_queue_max_frames gauge\ngochat_send_queue_max_frames %d\n", stats.MaxQueued)
+		fmt.Fprintf(w, "# HELP gochat_send_dropped_total Frames dropped for slow clients.\n# TYPE gochat_send_dropped_total counter\ngochat_send_dropped_total %d\n", stats.Dropped)
+		fmt.Fprintf(w, "# HELP gochat_slow_disconnects_total Clients disconnected for falling behind.\n# TYPE gochat_slow_disconnects_total counter\ngochat_slow_disconnects_total %d\n", stats.SlowDisconnects)
+	}
+}
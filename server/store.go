@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// ErrNotStored is returned by Store.Get and Store.Update for a message it
+// doesn't hold.
+var ErrNotStored = errors.New("message not stored")
+
+// Store persists chat messages so they can be replayed to clients.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Append records msg, which already carries its id
+	Append(msg *protocol.Envelope) error
+	// Update replaces the stored message with the same id and room
+	Update(msg *protocol.Envelope) error
+	// Get returns the message with id in room. It must not be modified, use
+	// Update with a copy instead.
+	Get(room string, id uint64) (*protocol.Envelope, error)
+	// Thread returns the message with id, or the first message of the thread
+	// it replies in, followed by every reply to it and to its replies. At
+	// most limit messages are returned, oldest first.
+	Thread(room string, id uint64, limit int) ([]*protocol.Envelope, error)
+	// Before returns up to limit messages of room with an id lower than
+	// before, oldest first. A before of 0 returns the latest messages. more
+	// reports whether even older messages exist.
+	Before(room string, before uint64, limit int) (msgs []*protocol.Envelope, more bool, err error)
+	// LastID returns the highest id stored, so ids keep increasing across restarts
+	LastID() uint64
+	Close() error
+}
+
+// MemoryStore keeps history in memory only. It is the default when no Store
+// is configured.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	limit  int
+	rooms  map[string][]*protocol.Envelope
+	lastID uint64
+}
+
+// NewMemoryStore returns a store holding at most limit messages per room,
+// 0 means no limit.
+func NewMemoryStore(limit int) *MemoryStore {
+	return &MemoryStore{
+		limit: limit,
+		rooms: map[string][]*protocol.Envelope{},
+	}
+}
+
+func (m *MemoryStore) Append(msg *protocol.Envelope) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgs := append(m.rooms[msg.Room], msg)
+	if m.limit > 0 && len(msgs) > m.limit {
+		msgs = msgs[len(msgs)-m.limit:]
+	}
+	m.rooms[msg.Room] = msgs
+
+	if msg.ID > m.lastID {
+		m.lastID = msg.ID
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Update(msg *protocol.Envelope) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Recent messages are the ones that change, search from the end
+	msgs := m.rooms[msg.Room]
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].ID == msg.ID {
+			msgs[i] = msg
+			return nil
+		}
+		if msgs[i].ID < msg.ID {
+			break
+		}
+	}
+
+	return ErrNotStored
+}
+
+func (m *MemoryStore) Get(room string, id uint64) (*protocol.Envelope, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i := m.index(room, id); i >= 0 {
+		return m.rooms[room][i], nil
+	}
+	return nil, ErrNotStored
+}
+
+func (m *MemoryStore) Thread(room string, id uint64, limit int) ([]*protocol.Envelope, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.index(room, id)
+	if i < 0 {
+		return nil, ErrNotStored
+	}
+
+	// Parents are always older than their replies, walk up to the first
+	// message still stored
+	msgs := m.rooms[room]
+	for msgs[i].ReplyTo != 0 {
+		parent := m.index(room, msgs[i].ReplyTo)
+		if parent < 0 || parent >= i {
+			break
+		}
+		i = parent
+	}
+
+	thread := []*protocol.Envelope{msgs[i]}
+	ids := map[uint64]bool{msgs[i].ID: true}
+	for _, msg := range msgs[i+1:] {
+		if len(thread) >= limit {
+			break
+		}
+		if ids[msg.ReplyTo] {
+			thread = append(thread, msg)
+			ids[msg.ID] = true
+		}
+	}
+
+	return thread, nil
+}
+
+// index returns where the message with id is in room, -1 if it isn't
+// stored. m.mu must be held.
+func (m *MemoryStore) index(room string, id uint64) int {
+	msgs := m.rooms[room]
+	for i := len(msgs) - 1; i >= 0 && msgs[i].ID >= id; i-- {
+		if msgs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MemoryStore) Before(room string, before uint64, limit int) ([]*protocol.Envelope, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msgs := m.rooms[room]
+
+	// Messages are appended in id order, so everything before end is older
+	end := len(msgs)
+	if before > 0 {
+		for end > 0 && msgs[end-1].ID >= before {
+			end--
+		}
+	}
+
+	start := max(end-limit, 0)
+
+	page := make([]*protocol.Envelope, end-start)
+	copy(page, msgs[start:end])
+
+	return page, start > 0, nil
+}
+
+func (m *MemoryStore) LastID() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastID
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
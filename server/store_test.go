@@ -0,0 +1,91 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// ids returns the ids of msgs in order.
+func ids(msgs []*protocol.Envelope) []uint64 {
+	var out []uint64
+	for _, m := range msgs {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+func TestMemoryStoreThread(t *testing.T) {
+	store := NewMemoryStore(100)
+
+	// 1 <- 2 <- 4, 1 <- 5, 3 stands alone, 6 answers the evicted 0
+	for _, m := range []struct{ id, replyTo uint64 }{{1, 0}, {2, 1}, {3, 0}, {4, 2}, {5, 1}, {6, 99}} {
+		store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: m.id, ReplyTo: m.replyTo})
+	}
+
+	tests := []struct {
+		id    uint64
+		limit int
+		want  []uint64
+		err   error
+	}{
+		{1, 10, []uint64{1, 2, 4, 5}, nil},
+		{4, 10, []uint64{1, 2, 4, 5}, nil},
+		{1, 2, []uint64{1, 2}, nil},
+		{3, 10, []uint64{3}, nil},
+		{6, 10, []uint64{6}, nil},
+		{7, 10, nil, ErrNotStored},
+	}
+
+	for _, tt := range tests {
+		got, err := store.Thread("general", tt.id, tt.limit)
+		if err != tt.err {
+			t.Errorf("Thread(%d, %d) error = %v, want %v", tt.id, tt.limit, err, tt.err)
+			continue
+		}
+		if !slices.Equal(ids(got), tt.want) {
+			t.Errorf("Thread(%d, %d) = %v, want %v", tt.id, tt.limit, ids(got), tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreBefore(t *testing.T) {
+	store := NewMemoryStore(5)
+	for id := uint64(1); id <= 7; id++ {
+		store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "general", ID: id})
+	}
+	store.Append(&protocol.Envelope{Type: protocol.TypeChat, Room: "random", ID: 8})
+
+	// Only 3 to 7 are left in #general
+	tests := []struct {
+		room   string
+		before uint64
+		limit  int
+		want   []uint64
+		more   bool
+	}{
+		{"general", 0, 10, []uint64{3, 4, 5, 6, 7}, false},
+		{"general", 0, 2, []uint64{6, 7}, true},
+		{"general", 6, 2, []uint64{4, 5}, true},
+		{"general", 4, 2, []uint64{3}, false},
+		{"general", 3, 2, nil, false},
+		{"general", 100, 5, []uint64{3, 4, 5, 6, 7}, false},
+		{"random", 0, 5, []uint64{8}, false},
+		{"empty", 0, 5, nil, false},
+	}
+
+	for _, tt := range tests {
+		got, more, err := store.Before(tt.room, tt.before, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids(got), tt.want) || more != tt.more {
+			t.Errorf("Before(%s, %d, %d) = %v, %v, want %v, %v", tt.room, tt.before, tt.limit, ids(got), more, tt.want, tt.more)
+		}
+	}
+
+	if got := store.LastID(); got != 8 {
+		t.Errorf("LastID() = %d, want 8", got)
+	}
+}
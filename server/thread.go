@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// replyQuote returns the quote of the message with id in room that a new
+// message answers.
+func (s *Server) replyQuote(room string, id uint64) (*protocol.Quote, error) {
+	parent, err := s.store.Get(room, id)
+	if errors.Is(err, ErrNotStored) {
+		return nil, fmt.Errorf("no message %d in #%s to reply to", id, room)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if parent.Type != protocol.TypeChat || parent.Deleted {
+		return nil, errors.New("only chat messages can be replied to")
+	}
+
+	return protocol.NewQuote(parent.Sender, parent.Body), nil
+}
+
+// quoteOf returns the quote of message id in room as it is now, nil if it is
+// no longer stored.
+func (s *Server) quoteOf(room string, id uint64) *protocol.Quote {
+	parent, err := s.store.Get(room, id)
+	if err != nil {
+		return nil
+	}
+
+	if parent.Deleted {
+		return &protocol.Quote{Sender: parent.Sender, Deleted: true}
+	}
+	return protocol.NewQuote(parent.Sender, parent.Body)
+}
+
+// withQuotes returns stored messages of room with the quotes of replies
+// added. Replies are copied, the stored messages are left as they are.
+func (s *Server) withQuotes(room string, msgs []*protocol.Envelope) []*protocol.Envelope {
+	out := make([]*protocol.Envelope, len(msgs))
+	for i, m := range msgs {
+		out[i] = m
+		if m.ReplyTo == 0 || m.Deleted {
+			continue
+		}
+
+		reply := *m
+		reply.Quote = s.quoteOf(room, m.ReplyTo)
+		out[i] = &reply
+	}
+	return out
+}
+
+// sendThread sends the thread the message with id in room is part of.
+func (s *Server) sendThread(sess *session, room string, id uint64) {
+	msgs, err := s.store.Thread(room, id, maxHistoryRequest)
+	if errors.Is(err, ErrNotStored) {
+		sess.send(protocol.NewError(fmt.Sprintf("no message %d in #%s", id, room)))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		sess.send(protocol.NewError("history unavailable"))
+		return
+	}
+
+	reply := &protocol.Envelope{
+		Type:    protocol.TypeThread,
+		Sender:  protocol.SenderServer,
+		ID:      msgs[0].ID,
+		Room:    room,
+		Time:    time.Now(),
+		History: s.withQuotes(room, msgs),
+	}
+
+	// Drop the latest replies rather than the start of the thread
+	for len(reply.History) > 1 {
+		if _, err := protocol.Encode(reply); err != protocol.ErrFrameTooLarge {
+			break
+		}
+		reply.History = reply.History[:len(reply.History)-1]
+	}
+
+	if err := sess.send(reply); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// Quotes of replies read from the store show their parent as it is now.
+func TestQuoteFollowsParent(t *testing.T) {
+	s := startServer(t, server.Config{})
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	waitFor(t, alice, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeNotice })
+
+	room := protocol.DefaultChannel
+	if _, err := alice.Post(room, "original"); err != nil {
+		t.Fatal(err)
+	}
+	parent := waitFor(t, bob, chatWith("original")).ID
+
+	if _, err := bob.Reply(room, parent, "answer"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := waitFor(t, alice, chatWith("answer")); reply.Quote == nil || reply.Quote.Body != "original" {
+		t.Fatalf("live reply quote = %+v, want original", reply.Quote)
+	}
+
+	thread := func() *protocol.Quote {
+		t.Helper()
+		if err := bob.RequestThread(room, parent); err != nil {
+			t.Fatal(err)
+		}
+		env := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeThread })
+		if len(env.History) != 2 {
+			t.Fatalf("thread has %d messages, want 2", len(env.History))
+		}
+		return env.History[1].Quote
+	}
+
+	alice.Edit(room, parent, "changed")
+	waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeEdit })
+	if q := thread(); q == nil || q.Body != "changed" || q.Deleted {
+		t.Errorf("quote after edit = %+v, want changed", q)
+	}
+
+	alice.Delete(room, parent)
+	waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeDelete })
+	if q := thread(); q == nil || q.Body != "" || !q.Deleted {
+		t.Errorf("quote after delete = %+v, want deleted", q)
+	}
+
+	if err := bob.RequestHistory(room, 0); err != nil {
+		t.Fatal(err)
+	}
+	history := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeHistory })
+	for _, m := range history.History {
+		if m.Body == "answer" && (m.Quote == nil || !m.Quote.Deleted || m.Quote.Body != "") {
+			t.Errorf("history quote = %+v, want deleted", m.Quote)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+)
+
+// tlsConfig returns the TLS configuration for both listeners, or nil when
+// TLS is disabled.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.cfg.TLSCertFile == "" && s.cfg.TLSKeyFile == "" && !s.cfg.TLSSelfSigned {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	var err error
+
+	switch {
+	case s.cfg.TLSSelfSigned:
+		cert, err = loadOrCreateSelfSigned(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	case s.cfg.TLSCertFile == "" || s.cfg.TLSKeyFile == "":
+		return nil, errors.New("TLS needs both a certificate and a key file")
+	default:
+		cert, err = tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	fmt.Printf("TLS certificate fingerprint: %s\n", protocol.CertFingerprint(cert.Certificate[0]))
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadOrCreateSelfSigned loads the certificate at certFile and keyFile or
+// generates one and writes it there, so its fingerprint survives restarts.
+// With empty paths the certificate only lives in memory.
+func loadOrCreateSelfSigned(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err == nil {
+			return cert, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return tls.Certificate{}, err
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if certFile != "" && keyFile != "" {
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Printf("Generated self-signed certificate %s\n", certFile)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func generateSelfSigned() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Go Chat"}, CommonName: "Go Chat self-signed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
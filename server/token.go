@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+)
+
+func getJoinToken(apiKey, apiSecret, room, identity string) (string, error) {
+	at := auth.NewAccessToken(apiKey, apiSecret)
+	grant := &auth.VideoGrant{
+		RoomJoin: true,
+		Room:     room,
+	}
+	at.SetVideoGrant(grant).
+		SetIdentity(identity).
+		SetValidFor(time.Hour)
+
+	return at.ToJWT()
+}
+
+func (s *Server) tokenHandler() http.HandlerFunc {
+	type tokenResponse struct {
+		JWTToken string `json:"jwtToken"`
+		HostURL  string `json:"hostUrl"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		displayName := r.URL.Query().Get("name")
+
+		if displayName == "" {
+			http.Error(w, "'name' parameter required", http.StatusBadRequest)
+			return
+		}
+
+		// Voice identities must match a logged in account, the login token
+		// from the welcome frame is sent as a bearer token
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, "login token required", http.StatusUnauthorized)
+			return
+		}
+
+		acc, ok := s.accountForToken(displayName, token)
+		if !ok {
+			http.Error(w, "invalid login token", http.StatusUnauthorized)
+			return
+		}
+		displayName = acc.Name
+
+		room, err := normalizeChannel(r.URL.Query().Get("room"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !s.channelExists(room) {
+			http.Error(w, fmt.Sprintf("channel #%s does not exist", room), http.StatusNotFound)
+			return
+		}
+
+		// Only members may listen in, as only members may read the channel
+		if !s.accountInChannel(displayName, room) {
+			http.Error(w, fmt.Sprintf("not in #%s, use #join %s first", room, room), http.StatusForbidden)
+			return
+		}
+
+		joinToken, err := getJoinToken(s.cfg.LiveKitAPIKey, s.cfg.LiveKitAPISecret, s.voiceRoom(room), displayName)
+		if err != nil {
+			log.Printf("failed to create join token: %q\n", err)
+			http.Error(w, "failed to create join token", http.StatusInternalServerError)
+			return
+		}
+
+		resp := tokenResponse{
+			JWTToken: joinToken,
+			HostURL:  s.cfg.LiveKitURL,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("failed to write JSON: %v\n", err)
+		}
+	}
+}
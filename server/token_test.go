@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTokenHandler(t *testing.T) {
+	s := New(Config{LiveKitAPIKey: "key", LiveKitAPISecret: "a secret long enough to sign with"})
+
+	acc := &Account{Name: "alice"}
+	token, err := acc.issueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.accounts.Put(acc); err != nil {
+		t.Fatal(err)
+	}
+
+	s.joinChannel(newSession(nil, "alice", 1, SlowConsumerDisconnect, time.Second), "general")
+	s.joinChannel(newSession(nil, "bob", 1, SlowConsumerDisconnect, time.Second), "random")
+
+	tests := []struct {
+		name, room, token string
+		want              int
+	}{
+		{"Alice", "general", token, http.StatusOK},
+		{"alice", "random", token, http.StatusForbidden},
+		{"alice", "nowhere", token, http.StatusNotFound},
+		{"alice", "general", "forged", http.StatusUnauthorized},
+		{"bob", "random", token, http.StatusUnauthorized},
+	}
+
+	handler := s.tokenHandler()
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/token?"+url.Values{"name": {tt.name}, "room": {tt.room}}.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s in #%s: status %d, want %d: %s", tt.name, tt.room, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/anthonybliss1/fyne-go-chat/chat/client"
+	"github.com/anthonybliss1/fyne-go-chat/protocol"
+	"github.com/anthonybliss1/fyne-go-chat/server"
+)
+
+// Typing frames reach the other members of the room and are never stored.
+func TestTypingRelay(t *testing.T) {
+	s := startServer(t, server.Config{})
+	alice := dial(t, s, "alice", true, false)
+	bob := dial(t, s, "bob", true, false)
+	carol := dial(t, s, "carol", true, false)
+	for _, c := range []*client.Client{alice, bob} {
+		c.Send(protocol.DefaultChannel, "#join games")
+		waitFor(t, c, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeJoined && env.Room == "games" })
+	}
+
+	typing := func(env *protocol.Envelope) bool { return env.Type == protocol.TypeTyping }
+
+	alice.Typing("games", true)
+	if env := waitFor(t, bob, typing); env.Sender != "alice" || env.Room != "games" || !env.Active {
+		t.Errorf("bob got typing %+v, want alice in #games", env)
+	}
+
+	// Frames arrive in order, a typing frame for alice or carol would come
+	// before this
+	bob.Send(protocol.DefaultChannel, "done")
+	for _, c := range []*client.Client{alice, carol} {
+		if env := waitFor(t, c, func(env *protocol.Envelope) bool { return typing(env) || chatWith("done")(env) }); typing(env) {
+			t.Errorf("%s got typing of %s in #%s", c.DisplayName(), env.Sender, env.Room)
+		}
+	}
+
+	if err := bob.RequestHistory("games", 0); err != nil {
+		t.Fatal(err)
+	}
+	history := waitFor(t, bob, func(env *protocol.Envelope) bool { return env.Type == protocol.TypeHistory })
+	for _, m := range history.History {
+		if m.Type != protocol.TypeChat && m.Type != protocol.TypeNotice {
+			t.Errorf("history of #games has a %s frame", m.Type)
+		}
+	}
+}